@@ -0,0 +1,53 @@
+package loggerhandler
+
+import (
+	"io"
+	"strings"
+)
+
+// GetRejectedCounter restituisce il contatore dei comandi rifiutati dopo Close.
+// Parametri: nessuno
+// Ritorna: Int64CounterLike (può essere nil)
+func (lh *LoggerHandler) GetRejectedCounter() Int64CounterLike {
+	return lh.rejectedCounter
+}
+
+// IsClosed indica se il LoggerHandler è stato chiuso e rifiuta i nuovi comandi.
+// Parametri: nessuno
+// Ritorna: bool
+func (lh *LoggerHandler) IsClosed() bool {
+	lh.sendMu.RLock()
+	defer lh.sendMu.RUnlock()
+	return lh.closed
+}
+
+// rejectAfterClose gestisce un comando inviato dopo Close.
+// Cosa fa: incrementa la metrica logger_rejected_after_close e, se è configurato un
+//
+//	fallback (vedi SetFallbackWriter), vi scrive il comando formattato in modo sincrono
+//	dalla goroutine chiamante, dato che la goroutine di scrittura è terminata.
+//	Il comando è segnato come concluso nel write-ahead log.
+//
+// Parametri: cmd comando rifiutato
+// Ritorna: nulla
+func (lh *LoggerHandler) rejectAfterClose(cmd LogCommand) {
+	lh.walDone(cmd)
+	if lh.meter != nil {
+		lh.rejectedCounter.Add(1)
+	}
+
+	lh.mu.Lock()
+	fallback := lh.fallbackWriter
+	lh.mu.Unlock()
+	if fallback == nil || (len(cmd.Records) == 0 && cmd.Op == OpLog) {
+		return
+	}
+
+	var sb strings.Builder
+	if err := lh.formatCommand(&sb, cmd); err != nil && sb.Len() == 0 {
+		return
+	}
+	lh.fallbackMu.Lock()
+	defer lh.fallbackMu.Unlock()
+	_, _ = io.WriteString(fallback, sb.String())
+}
@@ -0,0 +1,232 @@
+package loggerhandler
+
+import (
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// defaultBackpressureTimeout è l'attesa usata da BackpressureBlockWithTimeout quando il timeout
+// configurato non è positivo (vedi SetBackpressurePolicy).
+const defaultBackpressureTimeout = 100 * time.Millisecond
+
+// BackpressurePolicy stabilisce cosa fa AppendCommand quando il canale dei comandi è pieno.
+type BackpressurePolicy int
+
+const (
+	// BackpressureDropNewest scarta il comando in arrivo (comportamento predefinito).
+	BackpressureDropNewest BackpressurePolicy = iota
+	// BackpressureDropOldest scarta l'OpLog più vecchio in coda per fare spazio al comando nuovo;
+	// i comandi che rilasciano uno span non vengono mai scartati.
+	BackpressureDropOldest
+	// BackpressureBlock attende finché il canale non ha spazio.
+	BackpressureBlock
+	// BackpressureBlockWithTimeout attende al massimo il timeout configurato (100ms se non
+	// positivo), poi scarta il comando.
+	BackpressureBlockWithTimeout
+	// BackpressureNeverDropTerminal scarta i comandi OpLog ma attende per i comandi che
+	// rilasciano lo span (OpReleaseSuccess, OpReleaseFailure, OpTimeout).
+	BackpressureNeverDropTerminal
+)
+
+// String restituisce il nome della policy.
+// Parametri: nessuno
+// Ritorna: string
+func (p BackpressurePolicy) String() string {
+	switch p {
+	case BackpressureDropNewest:
+		return "DropNewest"
+	case BackpressureDropOldest:
+		return "DropOldest"
+	case BackpressureBlock:
+		return "Block"
+	case BackpressureBlockWithTimeout:
+		return "BlockWithTimeout"
+	case BackpressureNeverDropTerminal:
+		return "NeverDropTerminal"
+	default:
+		return "Unknown"
+	}
+}
+
+// SetBackpressurePolicy imposta la policy applicata quando il canale dei comandi è pieno.
+// Cosa fa: permette di scegliere per servizio tra latenza (drop) e completezza (attesa).
+//
+//	Il timeout è usato solo da BackpressureBlockWithTimeout: un valore <= 0 è sostituito
+//	dall'attesa predefinita di 100ms, così che la policy non attenda mai senza limite
+//	come BackpressureBlock.
+//
+// Parametri:
+//   - policy: policy da applicare
+//   - timeout: attesa massima per BackpressureBlockWithTimeout
+//
+// Ritorna: nulla
+func (lh *LoggerHandler) SetBackpressurePolicy(policy BackpressurePolicy, timeout time.Duration) {
+	if policy == BackpressureBlockWithTimeout && timeout <= 0 {
+		timeout = defaultBackpressureTimeout
+	}
+	lh.mu.Lock()
+	defer lh.mu.Unlock()
+	lh.backpressure = policy
+	lh.backpressureTimeout = timeout
+}
+
+// GetBackpressurePolicy restituisce la policy configurata e il relativo timeout.
+// Parametri: nessuno
+// Ritorna: BackpressurePolicy e time.Duration
+func (lh *LoggerHandler) GetBackpressurePolicy() (BackpressurePolicy, time.Duration) {
+	lh.mu.Lock()
+	defer lh.mu.Unlock()
+	return lh.backpressure, lh.backpressureTimeout
+}
+
+// GetEvictedCounter restituisce il contatore dei comandi scartati dalla coda da BackpressureDropOldest.
+// Parametri: nessuno
+// Ritorna: Int64CounterLike (può essere nil)
+func (lh *LoggerHandler) GetEvictedCounter() Int64CounterLike {
+	return lh.evictedCounter
+}
+
+// GetBlockedCounter restituisce il contatore degli invii che hanno dovuto attendere.
+// Parametri: nessuno
+// Ritorna: Int64CounterLike (può essere nil)
+func (lh *LoggerHandler) GetBlockedCounter() Int64CounterLike {
+	return lh.blockedCounter
+}
+
+// GetBlockedWaitCounter restituisce il tempo totale di attesa degli invii, in microsecondi.
+// Parametri: nessuno
+// Ritorna: Int64CounterLike (può essere nil)
+func (lh *LoggerHandler) GetBlockedWaitCounter() Int64CounterLike {
+	return lh.blockedWaitCounter
+}
+
+// GetBlockTimeoutCounter restituisce il contatore degli invii scartati per timeout dell'attesa.
+// Parametri: nessuno
+// Ritorna: Int64CounterLike (può essere nil)
+func (lh *LoggerHandler) GetBlockTimeoutCounter() Int64CounterLike {
+	return lh.blockTimeoutCounter
+}
+
+// isTerminal indica se l'operazione rilascia lo span.
+// Parametri: op tipo di operazione
+// Ritorna: bool
+func isTerminal(op OpType) bool {
+	return op != OpLog
+}
+
+// policyAttr restituisce l'opzione che etichetta una metrica con la policy di backpressure.
+// Cosa fa: le metriche logger_discarded_commands, logger_evicted_commands, logger_blocked_sends,
+//
+//	logger_blocked_wait_us e logger_block_timeouts riportano l'attributo "policy".
+//
+// Parametri: policy policy applicata all'invio
+// Ritorna: metric.AddOption
+func policyAttr(policy BackpressurePolicy) metric.AddOption {
+	return metric.WithAttributes(attribute.String("policy", policy.String()))
+}
+
+// sendDropOldest invia il comando scartando gli OpLog più vecchi finché c'è spazio.
+// Cosa fa: un comando tolto dalla coda che rilascia uno span non viene scartato ma
+//
+//	spostato sulla corsia prioritaria, attendendo se necessario; l'ordine rispetto agli
+//	OpLog dello stesso span ancora in coda è garantito da processPriority.
+//
+// Parametri:
+//   - sh: shard di destinazione
+//   - cmd: comando da inviare
+//
+// Ritorna: nulla
+func (lh *LoggerHandler) sendDropOldest(sh *shard, cmd LogCommand) {
+	for {
+		select {
+		case sh.channel <- cmd:
+			return
+		default:
+		}
+		// Canale pieno: tolgo il comando più vecchio (se il worker non l'ha già preso)
+		select {
+		case old := <-sh.channel:
+			sh.addPending(old.SpanID, -1)
+			if isTerminal(old.Op) {
+				if !lh.sendPriority(sh, old) {
+					lh.rejectAfterClose(old)
+				}
+				continue
+			}
+			lh.walDone(old)
+			if lh.meter != nil {
+				lh.evictedCounter.Add(1, policyAttr(BackpressureDropOldest))
+			}
+		default:
+		}
+	}
+}
+
+// sendPriority accoda un comando sulla corsia prioritaria attendendo spazio.
+// Cosa fa: è usato per i comandi che non vengono mai scartati, qualunque sia la policy
+//
+//	(OpTimeout e i rilasci tolti dalla coda da BackpressureDropOldest). La corsia
+//	prioritaria è svuotata per prima, quindi l'attesa dura al più un'elaborazione;
+//	l'attesa termina comunque quando Close smette di attendere (vedi SetCloseTimeout).
+//
+// Parametri:
+//   - sh: shard di destinazione
+//   - cmd: comando da inviare
+//
+// Ritorna: true se il comando è stato accodato
+func (lh *LoggerHandler) sendPriority(sh *shard, cmd LogCommand) bool {
+	select {
+	case sh.priority <- cmd:
+		return true
+	case <-lh.abandon:
+		return false
+	}
+}
+
+// sendBlocking invia il comando attendendo spazio nel canale.
+// Parametri:
+//   - sh: shard di destinazione
+//   - cmd: comando da inviare
+//   - policy: policy che ha richiesto l'attesa (attributo delle metriche)
+//   - timeout: attesa massima (0 per attendere senza limite)
+//
+// Ritorna: true se il comando è stato accodato, false se l'attesa è scaduta o se Close
+//
+//	ha smesso di attendere
+func (lh *LoggerHandler) sendBlocking(sh *shard, cmd LogCommand, policy BackpressurePolicy, timeout time.Duration) bool {
+	attr := policyAttr(policy)
+	if lh.meter != nil {
+		lh.blockedCounter.Add(1, attr)
+	}
+	start := time.Now()
+	defer func() {
+		if lh.meter != nil {
+			lh.blockedWaitCounter.Add(time.Since(start).Microseconds(), attr)
+		}
+	}()
+
+	if timeout <= 0 {
+		select {
+		case sh.channel <- cmd:
+			return true
+		case <-lh.abandon:
+			return false
+		}
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case sh.channel <- cmd:
+		return true
+	case <-lh.abandon:
+		return false
+	case <-timer.C:
+		if lh.meter != nil {
+			lh.blockTimeoutCounter.Add(1, attr)
+		}
+		return false
+	}
+}
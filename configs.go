@@ -1,10 +1,10 @@
 package loggerhandler
 
 import (
-	"gopkg.in/natefinch/lumberjack.v2"
+	"errors"
 	"io"
 	"log/slog"
-	"os"
+	"sync"
 )
 
 type WriterConfigs struct {
@@ -15,10 +15,20 @@ type WriterConfigs struct {
 	compress       bool   // Se true, i file di log di backup vengono compressi in gzip
 	multi          io.Writer
 	textHandler    *slog.TextHandler
+	formatter      Formatter // Formatter usato per l'output degli span scritti su questo writer
+
+	formatterMu sync.RWMutex // protegge formatter, letto dai worker durante la formattazione
+	sinksMu     sync.Mutex   // protegge sinks, multi e textHandler
+	writeMu     sync.Mutex   // serializza le scritture sui sink (una Write per comando)
+	sinks       []Sink       // destinazioni dell'output (console e file inclusi)
+	extraSinks  []Sink       // sink registrati con AddSink
+	// callback opzionale invocata per ogni scrittura fallita su un sink
+	onSinkError func(sink Sink, err error)
 }
 
 // NewLogConfigs crea e inizializza una struttura WriterConfigs.
-// Cosa fa: imposta i campi in base ai parametri, crea l'io.MultiWriter e init del TextHandler.
+// Cosa fa: imposta i campi in base ai parametri, crea i sink, l'io.MultiWriter e init del TextHandler.
+// Il Formatter predefinito è il banner testuale (vedi SetFormatter).
 // Parametri:
 //   - consoleLogging: abilita o meno il logging sulla console
 //   - fileLocation: percorso del file di log (se vuoto non viene creato un file)
@@ -34,34 +44,64 @@ func NewLogConfigs(consoleLogging bool, fileLocation string, fileMaxSize int, fi
 		fileMaxSize:    fileMaxSize,
 		fileMaxBackups: fileMaxBackups,
 		compress:       compress,
+		formatter:      NewBannerFormatter(),
 	}
 	wc.toMulti()
 	wc.initTextHandler()
 	return wc
 }
 
-// toMulti costruisce l'io.MultiWriter in base alla configurazione.
-// Cosa fa: aggiunge stdout e/o un writer su file (lumberjack) al MultiWriter.
+// toMulti costruisce l'elenco dei sink e l'io.Writer che scrive su tutti.
+// Cosa fa: aggiunge il sink console e/o il sink su file (lumberjack) in base alla
+//
+//	configurazione, seguiti dai sink registrati con AddSink. Il writer risultante
+//	scrive su ogni sink anche se uno dei precedenti fallisce.
+//
 // Parametri: nessuno
 // Ritorna: nulla
 func (wc *WriterConfigs) toMulti() {
-	// Creo un slice di writer
-	var writers []io.Writer
+	// Creo uno slice di sink
+	var sinks []Sink
 
 	if wc.consoleLogging {
-		writers = append(writers, os.Stdout)
+		sinks = append(sinks, NewConsoleSink())
 	}
 
 	if wc.fileLocation != "" {
-		fileWriter := &lumberjack.Logger{
-			Filename:   wc.fileLocation,
-			MaxSize:    wc.fileMaxSize,
-			MaxBackups: wc.fileMaxBackups,
-			Compress:   wc.compress,
-		}
-		writers = append(writers, fileWriter)
+		sinks = append(sinks, NewFileSink(wc.fileLocation, wc.fileMaxSize, wc.fileMaxBackups, wc.compress))
+	}
+	sinks = append(sinks, wc.extraSinks...)
+
+	wc.sinks = sinks
+	wc.multi = &fanOutWriter{mu: &wc.writeMu, sinks: sinks, onError: wc.reportSinkError}
+}
+
+// reportSinkError inoltra l'errore di un sink alla callback configurata.
+// Parametri:
+//   - sink: destinazione che ha fallito
+//   - err: errore restituito
+//
+// Ritorna: nulla
+func (wc *WriterConfigs) reportSinkError(sink Sink, err error) {
+	wc.sinksMu.Lock()
+	fn := wc.onSinkError
+	wc.sinksMu.Unlock()
+	if fn != nil {
+		fn(sink, err)
 	}
-	wc.multi = io.MultiWriter(writers...)
+}
+
+// SetOnSinkError imposta la callback invocata quando la scrittura su un sink fallisce.
+// Cosa fa: la callback viene chiamata dalla goroutine di scrittura, una volta per ogni
+//
+//	sink fallito; non deve bloccare.
+//
+// Parametri: fn callback (nil la disabilita)
+// Ritorna: nulla
+func (wc *WriterConfigs) SetOnSinkError(fn func(sink Sink, err error)) {
+	wc.sinksMu.Lock()
+	defer wc.sinksMu.Unlock()
+	wc.onSinkError = fn
 }
 
 // initTextHandler inizializza un TextHandler di slog usando il MultiWriter.
@@ -71,17 +111,74 @@ func (wc *WriterConfigs) initTextHandler() {
 	wc.textHandler = slog.NewTextHandler(wc.multi, nil)
 }
 
+// AddSink registra una nuova destinazione per l'output di questo writer.
+// Cosa fa: il sink riceve, come console e file, ogni output formattato; va registrato
+//
+//	prima di creare il LoggerHandler o comunque prima che sia in uso.
+//
+// Parametri: sink destinazione da aggiungere (nil viene ignorato)
+// Ritorna: nulla
+func (wc *WriterConfigs) AddSink(sink Sink) {
+	if sink == nil {
+		return
+	}
+	wc.sinksMu.Lock()
+	defer wc.sinksMu.Unlock()
+	wc.extraSinks = append(wc.extraSinks, sink)
+	wc.toMulti()
+	wc.initTextHandler()
+}
+
+// GetSinks restituisce le destinazioni configurate, console e file inclusi.
+// Parametri: nessuno
+// Ritorna: []Sink (copia)
+func (wc *WriterConfigs) GetSinks() []Sink {
+	wc.sinksMu.Lock()
+	defer wc.sinksMu.Unlock()
+	return append([]Sink(nil), wc.sinks...)
+}
+
+// Flush svuota i buffer di tutti i sink.
+// Parametri: nessuno
+// Ritorna: errore combinato dei sink che hanno fallito
+func (wc *WriterConfigs) Flush() error {
+	var errs []error
+	for _, sink := range wc.GetSinks() {
+		errs = append(errs, sink.Flush())
+	}
+	return errors.Join(errs...)
+}
+
+// Close svuota e chiude tutti i sink.
+// Parametri: nessuno
+// Ritorna: errore combinato dei sink che hanno fallito
+func (wc *WriterConfigs) Close() error {
+	var errs []error
+	for _, sink := range wc.GetSinks() {
+		errs = append(errs, sink.Flush(), sink.Close())
+	}
+	return errors.Join(errs...)
+}
+
 // GetTextHandler restituisce il TextHandler interno.
 // Parametri: nessuno
 // Ritorna: *slog.TextHandler (può essere nil)
 func (wc *WriterConfigs) GetTextHandler() *slog.TextHandler {
+	wc.sinksMu.Lock()
+	defer wc.sinksMu.Unlock()
 	return wc.textHandler
 }
 
 // GetMultiWriter restituisce l'io.Writer combinato.
+// Cosa fa: una Write scrive su tutti i sink; l'errore restituito combina un SinkError
+//
+//	per ogni sink fallito (vedi SinkErrors).
+//
 // Parametri: nessuno
 // Ritorna: io.Writer
 func (wc *WriterConfigs) GetMultiWriter() io.Writer {
+	wc.sinksMu.Lock()
+	defer wc.sinksMu.Unlock()
 	return wc.multi
 }
 
@@ -119,3 +216,31 @@ func (wc *WriterConfigs) GetFileMaxBackups() int {
 func (wc *WriterConfigs) IsCompressEnabled() bool {
 	return wc.compress
 }
+
+// SetFormatter imposta il Formatter usato per l'output scritto su questo writer.
+// Cosa fa: può essere chiamato anche mentre il LoggerHandler elabora comandi; i comandi
+//
+//	già in formattazione terminano con il Formatter precedente.
+//
+// Parametri: formatter Formatter da usare (nil ripristina il banner predefinito)
+// Ritorna: nulla
+func (wc *WriterConfigs) SetFormatter(formatter Formatter) {
+	if formatter == nil {
+		formatter = NewBannerFormatter()
+	}
+	wc.formatterMu.Lock()
+	defer wc.formatterMu.Unlock()
+	wc.formatter = formatter
+}
+
+// GetFormatter restituisce il Formatter configurato.
+// Parametri: nessuno
+// Ritorna: Formatter (il banner predefinito se non impostato)
+func (wc *WriterConfigs) GetFormatter() Formatter {
+	wc.formatterMu.RLock()
+	defer wc.formatterMu.RUnlock()
+	if wc.formatter == nil {
+		return NewBannerFormatter()
+	}
+	return wc.formatter
+}
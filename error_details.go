@@ -0,0 +1,160 @@
+package loggerhandler
+
+import (
+	"fmt"
+	"log/slog"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync/atomic"
+)
+
+// profondità massima della catena di errori e dello stack riportati nell'output
+const (
+	maxErrorDepth = 16
+	maxStackDepth = 32
+)
+
+// errorCause descrive un errore della catena ottenuta con errors.Unwrap / errors.Join.
+// Cosa fa: Causes contiene un solo elemento per gli errori che incapsulano un altro errore
+//
+//	(Unwrap() error) e un elemento per ramo per quelli multipli (Unwrap() []error).
+//	Con i Formatter JSON è serializzato come oggetto, con quelli testuali tramite String.
+//
+// Parametri: nessuno
+// Ritorna: nessuna (è una struttura)
+type errorCause struct {
+	Msg    string       `json:"msg"`
+	Type   string       `json:"type"`
+	Causes []errorCause `json:"causes,omitempty"`
+}
+
+// String restituisce la catena in forma compatta: tipo("messaggio") <- causa.
+// Parametri: nessuno
+// Ritorna: string
+func (c errorCause) String() string {
+	s := fmt.Sprintf("%s(%q)", c.Type, c.Msg)
+	switch len(c.Causes) {
+	case 0:
+		return s
+	case 1:
+		return s + " <- " + c.Causes[0].String()
+	}
+	branches := make([]string, len(c.Causes))
+	for i, cause := range c.Causes {
+		branches[i] = cause.String()
+	}
+	return s + " <- [" + strings.Join(branches, ", ") + "]"
+}
+
+// describeError costruisce la catena di un errore seguendo Unwrap() error e Unwrap() []error.
+// Parametri:
+//   - err: errore da descrivere
+//   - depth: livello corrente, limitato a maxErrorDepth
+//
+// Ritorna: errorCause
+func describeError(err error, depth int) errorCause {
+	c := errorCause{Msg: err.Error(), Type: fmt.Sprintf("%T", err)}
+	if depth >= maxErrorDepth {
+		return c
+	}
+	switch u := err.(type) {
+	case interface{ Unwrap() error }:
+		if inner := u.Unwrap(); inner != nil {
+			c.Causes = []errorCause{describeError(inner, depth+1)}
+		}
+	case interface{ Unwrap() []error }:
+		for _, inner := range u.Unwrap() {
+			if inner != nil {
+				c.Causes = append(c.Causes, describeError(inner, depth+1))
+			}
+		}
+	}
+	return c
+}
+
+// errorAttrs restituisce gli attributi strutturati del record di errore di uno span.
+// Cosa fa: produce il gruppo "error" con il tipo concreto dell'errore, la catena delle cause
+//
+//	(se presente) e lo stack catturato al punto del fallimento (se abilitato).
+//
+// Parametri:
+//   - err: errore del comando
+//   - stack: program counter catturati da captureStack (può essere nil)
+//
+// Ritorna: []slog.Attr
+func errorAttrs(err error, stack []uintptr) []slog.Attr {
+	root := describeError(err, 0)
+	attrs := []slog.Attr{slog.String("type", root.Type)}
+	if len(root.Causes) > 0 {
+		attrs = append(attrs, slog.Any("chain", root.Causes))
+	}
+	if frames := formatStack(stack); len(frames) > 0 {
+		attrs = append(attrs, slog.Any("stack", frames))
+	}
+	return []slog.Attr{{Key: "error", Value: slog.GroupValue(attrs...)}}
+}
+
+// SetCaptureStackTrace abilita la cattura dello stack nei punti in cui uno span fallisce.
+// Cosa fa: Error, ErrorContext, ReleaseFailure e ReleaseFailureContext salvano lo stack del
+//
+//	chiamante, riportato nel record di errore come attributo error.stack. La cattura ha un
+//	costo, per questo è disabilitata per default.
+//
+// Parametri: enabled true per catturare lo stack
+// Ritorna: nulla
+func (lh *LoggerHandler) SetCaptureStackTrace(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&lh.stackCapture, v)
+}
+
+// IsCaptureStackTrace indica se la cattura dello stack è abilitata.
+// Parametri: nessuno
+// Ritorna: bool
+func (lh *LoggerHandler) IsCaptureStackTrace() bool {
+	return atomic.LoadInt32(&lh.stackCapture) == 1
+}
+
+// captureStack cattura i program counter del chiamante se la cattura è abilitata.
+// Parametri: nessuno
+// Ritorna: []uintptr (nil se la cattura è disabilitata)
+func (lh *LoggerHandler) captureStack() []uintptr {
+	if lh == nil || !lh.IsCaptureStackTrace() {
+		return nil
+	}
+	pcs := make([]uintptr, maxStackDepth)
+	// salto runtime.Callers e captureStack; i frame del pacchetto sono tolti da formatStack
+	n := runtime.Callers(2, pcs)
+	return pcs[:n]
+}
+
+// prefisso delle funzioni di questo pacchetto, escluse dallo stack riportato
+var packagePrefix = strings.TrimSuffix(runtime.FuncForPC(reflect.ValueOf(describeError).Pointer()).Name(), "describeError")
+
+// formatStack converte i program counter in righe "funzione file:riga".
+// Cosa fa: salta i frame iniziali del pacchetto, così lo stack parte dal codice che ha
+//
+//	chiamato il metodo dello span.
+//
+// Parametri: stack program counter catturati da captureStack
+// Ritorna: []string
+func formatStack(stack []uintptr) []string {
+	if len(stack) == 0 {
+		return nil
+	}
+	frames := runtime.CallersFrames(stack)
+	var out []string
+	for {
+		frame, more := frames.Next()
+		if len(out) > 0 || !strings.HasPrefix(frame.Function, packagePrefix) {
+			out = append(out, fmt.Sprintf("%s %s:%d", frame.Function, frame.File, frame.Line))
+		}
+		if !more {
+			break
+		}
+	}
+	return out
+}
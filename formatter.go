@@ -0,0 +1,305 @@
+package loggerhandler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Formatter trasforma un LogCommand nella rappresentazione testuale scritta sui writer.
+// Cosa fa: viene scelto per ogni WriterConfigs; riceve il comando con i record già completi
+//
+//	(attributi di correlazione e record di errore inclusi) e scrive l'output su w.
+//	L'output deve terminare con un ritorno a capo.
+//
+// Parametri: nessuno
+// Ritorna: nessuna (è un'interfaccia)
+type Formatter interface {
+	// Format scrive la rappresentazione del comando.
+	// Parametri:
+	//  - ctx: contesto del comando, da passare agli slog.Handler context-aware
+	//  - w: destinazione dell'output
+	//  - cmd: comando da formattare
+	// Ritorna: errore in caso di fallimento della formattazione
+	Format(ctx context.Context, w io.Writer, cmd LogCommand) error
+}
+
+// bannerFormatter produce il formato storico: header con OpType e Span ID,
+// un record JSON per riga e una riga di chiusura.
+type bannerFormatter struct{}
+
+// NewBannerFormatter crea il Formatter con il banner testuale (formato predefinito).
+// Parametri: nessuno
+// Ritorna: Formatter
+func NewBannerFormatter() Formatter {
+	return bannerFormatter{}
+}
+
+// Format scrive header, record JSON e riga di chiusura.
+// Cosa fa: un comando senza record non produce output.
+// Parametri:
+//   - ctx: contesto del comando
+//   - w: destinazione dell'output
+//   - cmd: comando da formattare
+//
+// Ritorna: errore di scrittura
+func (bannerFormatter) Format(ctx context.Context, w io.Writer, cmd LogCommand) error {
+	if len(cmd.Records) == 0 {
+		return nil
+	}
+	// Aggiungo una riga di separazione
+	header := "----- OpType: " + cmd.TypeString() + " ----- Span ID: " + cmd.SpanID + " -----"
+	if cmd.ParentID != "" {
+		header += " Parent ID: " + cmd.ParentID + " -----"
+	}
+	if cmd.TraceID != "" {
+		header += " Trace ID: " + cmd.TraceID + " -----"
+	}
+	if cmd.W3CSpanID != "" {
+		header += " W3C Span ID: " + cmd.W3CSpanID + " -----"
+	}
+	if _, err := io.WriteString(w, header+"\n"); err != nil {
+		return err
+	}
+
+	handler := slog.NewJSONHandler(w, nil)
+	for _, record := range cmd.Records {
+		if err := handler.Handle(ctx, record); err != nil {
+			return err
+		}
+	}
+
+	// Aggiungo una riga di chiusura
+	_, err := io.WriteString(w, "--------------------\n")
+	return err
+}
+
+// jsonLinesFormatter scrive un oggetto JSON per record, con i campi dello span.
+type jsonLinesFormatter struct{}
+
+// NewJSONLinesFormatter crea il Formatter JSON Lines: un record per riga, senza header,
+// con i campi span_id, op (e parent_id se presente) su ogni riga.
+// Parametri: nessuno
+// Ritorna: Formatter
+func NewJSONLinesFormatter() Formatter {
+	return jsonLinesFormatter{}
+}
+
+// Format scrive un oggetto JSON per ogni record.
+// Parametri:
+//   - ctx: contesto del comando
+//   - w: destinazione dell'output
+//   - cmd: comando da formattare
+//
+// Ritorna: errore di scrittura
+func (jsonLinesFormatter) Format(ctx context.Context, w io.Writer, cmd LogCommand) error {
+	return handleWithSpanFields(ctx, slog.NewJSONHandler(w, nil), cmd)
+}
+
+// logfmtFormatter scrive un record per riga nel formato logfmt (chiave=valore).
+type logfmtFormatter struct{}
+
+// NewLogfmtFormatter crea il Formatter logfmt: un record per riga, con i campi
+// span_id, op (e parent_id se presente) su ogni riga.
+// Parametri: nessuno
+// Ritorna: Formatter
+func NewLogfmtFormatter() Formatter {
+	return logfmtFormatter{}
+}
+
+// Format scrive una riga logfmt per ogni record.
+// Parametri:
+//   - ctx: contesto del comando
+//   - w: destinazione dell'output
+//   - cmd: comando da formattare
+//
+// Ritorna: errore di scrittura
+func (logfmtFormatter) Format(ctx context.Context, w io.Writer, cmd LogCommand) error {
+	return handleWithSpanFields(ctx, slog.NewTextHandler(w, nil), cmd)
+}
+
+// handleWithSpanFields passa i record a un handler di slog aggiungendo i campi dello span.
+// Parametri:
+//   - ctx: contesto del comando
+//   - handler: handler di slog che scrive l'output
+//   - cmd: comando da formattare
+//
+// Ritorna: errore di scrittura
+func handleWithSpanFields(ctx context.Context, handler slog.Handler, cmd LogCommand) error {
+	fields := []slog.Attr{slog.String("op", cmd.TypeString())}
+	if cmd.OtelSpanID != "" {
+		// span_id sul record è quello di tracing, conservo anche l'id dello span di log
+		fields = append(fields, slog.String("log_span_id", cmd.SpanID))
+	}
+	if cmd.ParentID != "" {
+		fields = append(fields, slog.String("parent_id", cmd.ParentID))
+	}
+	handler = handler.WithAttrs(fields)
+	for _, record := range cmd.Records {
+		if err := handler.Handle(ctx, record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// textFormatter scrive un record per riga in formato testuale leggibile.
+type textFormatter struct{}
+
+// NewTextFormatter crea il Formatter di testo semplice, pensato per la console:
+// "<time> <LEVEL> [<op> <span_id>] <msg> chiave=valore ...".
+// Parametri: nessuno
+// Ritorna: Formatter
+func NewTextFormatter() Formatter {
+	return textFormatter{}
+}
+
+// Format scrive una riga di testo per ogni record.
+// Parametri:
+//   - ctx: contesto del comando (non usato)
+//   - w: destinazione dell'output
+//   - cmd: comando da formattare
+//
+// Ritorna: errore di scrittura
+func (textFormatter) Format(_ context.Context, w io.Writer, cmd LogCommand) error {
+	var sb strings.Builder
+	for _, record := range cmd.Records {
+		sb.Reset()
+		sb.WriteString(record.Time.Format(time.RFC3339Nano))
+		sb.WriteString(" ")
+		sb.WriteString(record.Level.String())
+		sb.WriteString(" [" + cmd.TypeString() + " " + cmd.SpanID + "] ")
+		sb.WriteString(record.Message)
+		record.Attrs(func(a slog.Attr) bool {
+			appendTextAttr(&sb, "", a)
+			return true
+		})
+		sb.WriteString("\n")
+		if _, err := io.WriteString(w, sb.String()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// appendTextAttr aggiunge un attributo nel formato chiave=valore, appiattendo i gruppi
+// con chiavi separate da punto.
+// Parametri:
+//   - sb: builder di destinazione
+//   - prefix: prefisso dei gruppi esterni
+//   - a: attributo da scrivere
+//
+// Ritorna: nulla
+func appendTextAttr(sb *strings.Builder, prefix string, a slog.Attr) {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+	key := a.Key
+	if prefix != "" && key != "" {
+		key = prefix + "." + key
+	} else if key == "" {
+		key = prefix
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		for _, ga := range a.Value.Group() {
+			appendTextAttr(sb, key, ga)
+		}
+		return
+	}
+	value := a.Value.String()
+	if strings.ContainsAny(value, " \"=\n") || value == "" {
+		value = strconv.Quote(value)
+	}
+	sb.WriteString(" " + key + "=" + value)
+}
+
+// jsonDocumentFormatter scrive un unico documento JSON per comando, con i metadati
+// dello span e l'array dei record.
+type jsonDocumentFormatter struct{}
+
+// spanDocument è la struttura serializzata da jsonDocumentFormatter.
+type spanDocument struct {
+	SpanID     string            `json:"span_id"`
+	ParentID   string            `json:"parent_id,omitempty"`
+	TraceID    string            `json:"trace_id,omitempty"`
+	W3CSpanID  string            `json:"w3c_span_id,omitempty"`
+	Op         string            `json:"op"`
+	Tags       []string          `json:"tags"`
+	StartTime  time.Time         `json:"start_time"`
+	EndTime    time.Time         `json:"end_time"`
+	DurationMs float64           `json:"duration_ms"`
+	Outcome    string            `json:"outcome"`
+	Error      *string           `json:"error"`
+	Records    []json.RawMessage `json:"records"`
+}
+
+// NewJSONDocumentFormatter crea il Formatter a documento singolo: ogni LogCommand diventa
+// un oggetto JSON su una riga con span_id, op, tags, start_time, end_time, duration_ms,
+// outcome, error e l'array records (un oggetto per record, come in JSON Lines).
+// Parametri: nessuno
+// Ritorna: Formatter
+func NewJSONDocumentFormatter() Formatter {
+	return jsonDocumentFormatter{}
+}
+
+// Format scrive il documento JSON dello span.
+// Parametri:
+//   - ctx: contesto del comando
+//   - w: destinazione dell'output
+//   - cmd: comando da formattare
+//
+// Ritorna: errore di serializzazione o di scrittura
+func (jsonDocumentFormatter) Format(ctx context.Context, w io.Writer, cmd LogCommand) error {
+	// Serializzo ogni record con l'handler JSON di slog
+	var buf bytes.Buffer
+	handler := slog.NewJSONHandler(&buf, nil)
+	records := make([]json.RawMessage, 0, len(cmd.Records))
+	for _, record := range cmd.Records {
+		buf.Reset()
+		if err := handler.Handle(ctx, record); err != nil {
+			return err
+		}
+		raw := bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+		records = append(records, append(json.RawMessage(nil), raw...))
+	}
+
+	endTime := cmd.Time
+	if endTime.IsZero() {
+		endTime = time.Now()
+	}
+	var duration time.Duration
+	if !cmd.StartTime.IsZero() {
+		duration = endTime.Sub(cmd.StartTime)
+	}
+	tags := cmd.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+
+	doc := spanDocument{
+		SpanID:     cmd.SpanID,
+		ParentID:   cmd.ParentID,
+		TraceID:    cmd.TraceID,
+		W3CSpanID:  cmd.W3CSpanID,
+		Op:         cmd.TypeString(),
+		Tags:       tags,
+		StartTime:  cmd.StartTime,
+		EndTime:    endTime,
+		DurationMs: float64(duration) / float64(time.Millisecond),
+		Outcome:    cmd.Outcome(),
+		Records:    records,
+	}
+	if cmd.Err != nil {
+		msg := cmd.Err.Error()
+		doc.Error = &msg
+	}
+
+	// json.Encoder aggiunge il ritorno a capo finale
+	return json.NewEncoder(w).Encode(doc)
+}
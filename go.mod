@@ -4,11 +4,10 @@ go 1.24.0
 
 require (
 	github.com/google/uuid v1.6.0
+	go.opentelemetry.io/otel v1.39.0
 	go.opentelemetry.io/otel/metric v1.39.0
+	go.opentelemetry.io/otel/trace v1.39.0
 	gopkg.in/natefinch/lumberjack.v2 v2.2.1
 )
 
-require (
-	github.com/cespare/xxhash/v2 v2.3.0 // indirect
-	go.opentelemetry.io/otel v1.39.0 // indirect
-)
+require github.com/cespare/xxhash/v2 v2.3.0 // indirect
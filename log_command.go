@@ -1,6 +1,10 @@
 package loggerhandler
 
-import "log/slog"
+import (
+	"context"
+	"log/slog"
+	"time"
+)
 
 type OpType int
 
@@ -10,23 +14,52 @@ const (
 	// OpReleaseSuccess: rilascio dello span con successo
 	// OpReleaseFailure: rilascio dello span per errore
 	// OpTimeout: rilascio dello span per timeout
+	// OpAborted: span ancora aperto chiuso forzatamente da Shutdown
+	// OpLostOnCrash: span mai rilasciato ricostruito dal write-ahead log dopo un crash
 	OpLog OpType = iota
 	OpReleaseSuccess
 	OpReleaseFailure
 	OpTimeout
+	OpAborted
+	OpLostOnCrash
 )
 
 // LogCommand è la struttura che descrive un'operazione da eseguire sul LoggerHandler.
 // Campi:
 //   - Op: tipo di operazione (OpType)
 //   - SpanID: identificatore dello span a cui il comando si riferisce
+//   - ParentID: identificatore dello span padre (vuoto per uno span radice)
+//   - TraceID: identificatore della trace a cui appartiene lo span
+//   - OtelSpanID: span ID dello span di tracing OpenTelemetry collegato (vuoto se assente)
+//   - W3CSpanID: span ID W3C inviato ai servizi chiamati come parent-id (vuoto se non rappresentabile)
 //   - Records: slice di slog.Record accumulati nello span
 //   - Err: errore opzionale (usato per OpReleaseFailure e OpTimeout)
+//   - Ctx: contesto della chiamata che ha generato il comando (può essere nil)
+//   - Tags: tag dello span
+//   - StartTime: istante di creazione dello span
+//   - Time: istante in cui il comando è stato inviato
 type LogCommand struct {
-	Op      OpType
-	SpanID  string
-	Records []slog.Record
-	Err     error // Usato solo per OpReleaseFailure
+	Op         OpType
+	SpanID     string
+	ParentID   string
+	TraceID    string
+	OtelSpanID string
+	W3CSpanID  string
+	Records    []slog.Record
+	Err        error // Usato solo per OpReleaseFailure
+	Ctx        context.Context
+	Tags       []string
+	StartTime  time.Time
+	Time       time.Time
+
+	// numero progressivo del comando nello span, usato dal write-ahead log
+	walSeq uint64
+	// record registrati nel write-ahead log consumati dal comando (inclusi quelli scartati dal buffer)
+	walRecords int
+	// i record consumati sono gli ultimi registrati invece dei primi (vedi sendRecord)
+	walTail bool
+	// stack catturato al punto del fallimento (vedi SetCaptureStackTrace)
+	stack []uintptr
 }
 
 // TypeString restituisce una rappresentazione testuale del tipo di operazione.
@@ -42,7 +75,33 @@ func (lc *LogCommand) TypeString() string {
 		return "ReleaseFailure"
 	case OpTimeout:
 		return "Timeout"
+	case OpAborted:
+		return "Aborted"
+	case OpLostOnCrash:
+		return "LostOnCrash"
 	default:
 		return "Unknown"
 	}
 }
+
+// Outcome restituisce l'esito dello span rappresentato dal comando.
+// Parametri: nessuno
+// Ritorna: "in_progress" per OpLog, "success", "failure", "timeout", "aborted", "lost" o "unknown"
+func (lc *LogCommand) Outcome() string {
+	switch lc.Op {
+	case OpLog:
+		return "in_progress"
+	case OpReleaseSuccess:
+		return "success"
+	case OpReleaseFailure:
+		return "failure"
+	case OpTimeout:
+		return "timeout"
+	case OpAborted:
+		return "aborted"
+	case OpLostOnCrash:
+		return "lost"
+	default:
+		return "unknown"
+	}
+}
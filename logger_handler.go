@@ -2,8 +2,11 @@ package loggerhandler
 
 import (
 	"context"
-	"fmt"
+	"encoding/hex"
+	"errors"
+	"io"
 	"log/slog"
+	"sort"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -11,32 +14,71 @@ import (
 
 	"github.com/google/uuid"
 	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type LoggerHandler struct {
 	logWriter *WriterConfigs
 	errWriter *WriterConfigs
 
-	tmpHandler *slog.JSONHandler
-	strBuilder strings.Builder
-
 	meter MeterInterface
 	spans map[string]*SpanLogger
-
-	// Gestione timeout
-	timeouts map[string]*time.Timer
-	// canale che trasporta lo spanID quando scade un timer
-	chTimers chan string
-
-	channel   chan LogCommand
+	// span figli ancora aperti per ogni span padre (parentID -> insieme di spanID)
+	children map[string]map[string]struct{}
+	// tracer opzionale per collegare gli span agli span di tracing OpenTelemetry
+	tracer TracerInterface
+
+	// write-ahead log opzionale (nil se disabilitato)
+	wal *writeAheadLog
+	// errore di apertura o di replay del write-ahead log
+	walErr error
+
+	// policy applicata quando il canale dei comandi è pieno
+	backpressure BackpressurePolicy
+	// attesa massima per BackpressureBlockWithTimeout
+	backpressureTimeout time.Duration
+	// policy applicata dagli span quando il loro buffer è pieno
+	spanBufferPolicy SpanBufferPolicy
+	// modalità applicata ai nuovi span
+	spanMode SpanMode
+
+	// Gestione degli errori di scrittura
+	// callback opzionale invocata quando l'output di un comando non viene scritto
+	onWriteError func(cmd LogCommand, err error)
+	// destinazione usata quando nessun sink riceve l'output
+	fallbackWriter io.Writer
+	// file in cui salvare l'output non scritto per una successiva riproduzione
+	deadLetter Sink
+
+	// Gestione timeout: un'unica goroutine con le scadenze di tutti gli span
+	timers *timeoutScheduler
+
+	// attesa massima di Close per gli invii bloccati e le goroutine di elaborazione
+	closeTimeout time.Duration
+	// abandon viene chiuso quando Close smette di attendere (vedi SetCloseTimeout)
+	abandon chan struct{}
+	// closeErr è l'esito della chiusura (ErrCloseTimeout se le goroutine non hanno terminato)
+	closeErr error
+
+	// shard che elaborano i comandi, ognuno con le proprie code
+	shards    []*shard
 	wg        *sync.WaitGroup
 	closeOnce *sync.Once
 	mu        *sync.Mutex
 
-	// sincronizzazione per i callback dei timer
-	timersWg *sync.WaitGroup
 	// flag atomico che indica che il logger è in fase di chiusura
 	closing int32
+	// flag atomico che indica che Shutdown è in corso e non si accettano nuovi span
+	shuttingDown int32
+	// flag atomico che abilita la cattura dello stack nei punti di fallimento degli span
+	stackCapture int32
+	// sendMu protegge closed: gli invii lo acquisiscono in lettura, Close in scrittura
+	// prima di chiudere i canali, così nessun invio avviene su un canale chiuso
+	sendMu *sync.RWMutex
+	// closed indica che i canali dei comandi sono chiusi
+	closed bool
+	// fallbackMu serializza le scritture su fallback e dead-letter (da più shard e dopo la chiusura)
+	fallbackMu *sync.Mutex
 
 	// Metriche Otel per Report Temporali
 	// Contatori cumulativi
@@ -49,13 +91,35 @@ type LoggerHandler struct {
 	discardedCounter Int64CounterLike
 	// Contatori di LogCommand con Span scaduti o non presenti
 	invalidSpanCounter Int64CounterLike
+	// Contatori di LogCommand tolti dalla coda da BackpressureDropOldest
+	evictedCounter Int64CounterLike
+	// Contatori degli invii che hanno atteso spazio nel canale
+	blockedCounter Int64CounterLike
+	// Tempo totale di attesa degli invii, in microsecondi
+	blockedWaitCounter Int64CounterLike
+	// Contatori degli invii scartati per timeout dell'attesa
+	blockTimeoutCounter Int64CounterLike
+	// Contatori dei LogCommand rifiutati dopo Close
+	rejectedCounter Int64CounterLike
+	// Contatori delle scritture fallite sui singoli sink
+	sinkErrorCounter Int64CounterLike
+	// Contatori dei LogCommand il cui output non è stato scritto
+	writeErrorCounter Int64CounterLike
+	// Contatori degli span recuperati dal write-ahead log dopo un crash
+	lostOnCrashCounter Int64CounterLike
+	// Contatori delle scritture fallite sul write-ahead log
+	walErrorCounter Int64CounterLike
+	// Record scartati dal buffer degli span (SpanBufferRing e SpanBufferKeepFirst)
+	droppedRecordsCounter Int64CounterLike
+	// Record di debug e info scartati al rilascio con successo in modalità flight recorder
+	flightDiscardedCounter Int64CounterLike
 
 	// Indicatori istantanei
 	activeSpansGauge Int64UpDownCounterLike
 }
 
 // NewLoggerHandler crea e inizializza un nuovo LoggerHandler.
-// Cosa fa: alloca le strutture dati, inizializza handler temporaneo e metriche,
+// Cosa fa: alloca le strutture dati, inizializza le metriche
 //
 //	e avvia le goroutine che processano comandi e notifiche di timeout.
 //
@@ -63,87 +127,80 @@ type LoggerHandler struct {
 //   - logConfig: configurazione per il writer dei log normali
 //   - errConfig: configurazione per il writer degli errori
 //   - meter: implementazione di MeterInterface per creare metriche
-//   - bufferSize: dimensione del canale di comandi
+//   - bufferSize: dimensione del canale di comandi; un ulteriore quarto (almeno 1) è
+//     riservato alla corsia prioritaria di OpReleaseFailure e OpTimeout
 //
 // Ritorna: puntatore a LoggerHandler completamente inizializzato
 func NewLoggerHandler(logConfig *WriterConfigs, errConfig *WriterConfigs, meter MeterInterface, bufferSize int) *LoggerHandler {
+	return NewLoggerHandlerWithOptions(logConfig, errConfig, meter, bufferSize, HandlerOptions{})
+}
+
+// NewLoggerHandlerWithOptions crea un LoggerHandler con le opzioni facoltative indicate.
+// Cosa fa: come NewLoggerHandler, ma avvia opts.Shards goroutine di elaborazione; ogni
+//
+//	shard ha le proprie code (bufferSize ciascuna) e il proprio buffer di formattazione.
+//	Con più shard l'ordine è garantito solo tra i comandi dello stesso span.
+//
+// Parametri:
+//   - logConfig: configurazione per il writer dei log normali
+//   - errConfig: configurazione per il writer degli errori
+//   - meter: implementazione di MeterInterface per creare metriche
+//   - bufferSize: dimensione del canale di comandi di ogni shard
+//   - opts: opzioni facoltative
+//
+// Ritorna: puntatore a LoggerHandler completamente inizializzato
+func NewLoggerHandlerWithOptions(logConfig *WriterConfigs, errConfig *WriterConfigs, meter MeterInterface, bufferSize int, opts HandlerOptions) *LoggerHandler {
 	lh := &LoggerHandler{
-		logWriter: logConfig,
-		errWriter: errConfig,
-		meter:     meter,
-		spans:     make(map[string]*SpanLogger),
-		// mappa dei timer per span
-		timeouts: make(map[string]*time.Timer),
-		// canale per notifiche di timeout (trasporta lo spanID)
-		chTimers:  make(chan string, 128),
-		channel:   make(chan LogCommand, bufferSize),
-		wg:        &sync.WaitGroup{},
-		closeOnce: &sync.Once{},
-		mu:        &sync.Mutex{},
-		// timersWg per sincronizzare callback dei timer
-		timersWg: &sync.WaitGroup{},
-		closing:  0,
-	}
-
-	// Creo un handler temporaneo per la scrittura su buffer
-	err := lh.initTempHandler()
-	if err != nil {
-		panic("Errore nell'inizializzazione dell'handler temporaneo: " + err.Error())
+		logWriter:  logConfig,
+		errWriter:  errConfig,
+		meter:      meter,
+		spans:      make(map[string]*SpanLogger),
+		children:   make(map[string]map[string]struct{}),
+		wg:         &sync.WaitGroup{},
+		closeOnce:  &sync.Once{},
+		mu:         &sync.Mutex{},
+		sendMu:     &sync.RWMutex{},
+		fallbackMu: &sync.Mutex{},
+		closing:    0,
+
+		closeTimeout: defaultCloseTimeout,
+		abandon:      make(chan struct{}),
 	}
 
 	// Inizializzo le metriche
-	err = lh.initMetrics()
+	err := lh.initMetrics()
 	if err != nil {
 		panic("Errore nell'inizializzazione delle metriche: " + err.Error())
 	}
 
-	lh.wg.Add(1)
-	go func() {
-		defer lh.wg.Done()
-		for cmd := range lh.channel {
-			lh.processCommand(cmd)
+	// Recupero gli span di un eventuale crash precedente e apro un nuovo segmento
+	if opts.WALDir != "" {
+		lh.walErr = lh.replayWriteAheadLog(opts.WALDir)
+		wal, err := openWriteAheadLog(opts.WALDir, opts.WALSegmentSize)
+		if err != nil {
+			lh.walErr = errors.Join(lh.walErr, err)
+		} else {
+			lh.wal = wal
 		}
-	}()
-
-	// Goroutine che ascolta le notifiche dei timer e chiama Timeout sullo Span
-	lh.wg.Add(1)
-	go func() {
-		defer lh.wg.Done()
-		for spanID := range lh.chTimers {
-			// prendo lo span sotto mutex e lo invoco
-			lh.mu.Lock()
-			span := lh.spans[spanID]
-			lh.mu.Unlock()
+	}
 
-			if span == nil {
-				// Se non è stato configurato un Meter (può essere nil), non possiamo aggiornare le metriche.
-				// Le metriche sono opzionali: aggiorniamo solo se `lh.meter` è presente.
-				if lh.meter != nil {
-					lh.invalidSpanCounter.Add(1)
-				}
-				continue
-			}
+	shards := max(opts.Shards, 1)
+	for i := 0; i < shards; i++ {
+		sh := newShard(bufferSize)
+		lh.shards = append(lh.shards, sh)
+		lh.wg.Add(1)
+		go func() {
+			defer lh.wg.Done()
+			lh.run(sh)
+		}()
+	}
 
-			// invoca il metodo Timeout sullo SpanLogger
-			span.Timeout()
-		}
-	}()
+	// Scheduler dei timeout: alla scadenza chiama Timeout sullo span
+	lh.timers = newTimeoutScheduler(lh.fireTimeout)
 
 	return lh
 }
 
-// initTempHandler inizializza un handler JSON temporaneo usato per costruire
-// la rappresentazione testuale dei record prima di scriverli.
-// Cosa fa: crea uno strings.Builder e un JSONHandler che lo usa.
-// Parametri: nessuno
-// Ritorna: errore in caso di fallimento (attualmente sempre nil)
-func (lh *LoggerHandler) initTempHandler() error {
-	lh.strBuilder = strings.Builder{}
-
-	lh.tmpHandler = slog.NewJSONHandler(&lh.strBuilder, nil)
-	return nil
-}
-
 // initMetrics crea le metriche richieste tramite il MeterInterface.
 // Cosa fa: richiama i metodi del meter per ottenere i contatori e gli indicatori.
 // Parametri: nessuno
@@ -166,6 +223,58 @@ func (lh *LoggerHandler) initMetrics() error {
 	if err != nil {
 		return err
 	}
+	lh.discardedCounter, err = lh.meter.Int64Counter("logger_discarded_commands", metric.WithDescription("Somma totale dei comandi scartati perchè il buffer era pieno"))
+	if err != nil {
+		return err
+	}
+	lh.invalidSpanCounter, err = lh.meter.Int64Counter("logger_invalid_spans", metric.WithDescription("Somma totale dei comandi riferiti a span scaduti o non presenti"))
+	if err != nil {
+		return err
+	}
+	lh.evictedCounter, err = lh.meter.Int64Counter("logger_evicted_commands", metric.WithDescription("Somma totale dei comandi tolti dalla coda per fare spazio ai nuovi"))
+	if err != nil {
+		return err
+	}
+	lh.blockedCounter, err = lh.meter.Int64Counter("logger_blocked_sends", metric.WithDescription("Somma totale degli invii che hanno atteso spazio nel buffer"))
+	if err != nil {
+		return err
+	}
+	lh.blockedWaitCounter, err = lh.meter.Int64Counter("logger_blocked_wait_us", metric.WithDescription("Tempo totale di attesa degli invii in microsecondi"))
+	if err != nil {
+		return err
+	}
+	lh.blockTimeoutCounter, err = lh.meter.Int64Counter("logger_block_timeouts", metric.WithDescription("Somma totale degli invii scartati per timeout dell'attesa"))
+	if err != nil {
+		return err
+	}
+	lh.rejectedCounter, err = lh.meter.Int64Counter("logger_rejected_after_close", metric.WithDescription("Somma totale dei comandi rifiutati perchè il logger era chiuso"))
+	if err != nil {
+		return err
+	}
+	lh.sinkErrorCounter, err = lh.meter.Int64Counter("logger_sink_errors", metric.WithDescription("Somma totale delle scritture fallite sui sink"))
+	if err != nil {
+		return err
+	}
+	lh.writeErrorCounter, err = lh.meter.Int64Counter("logger_write_errors", metric.WithDescription("Somma totale dei comandi il cui output non è stato scritto"))
+	if err != nil {
+		return err
+	}
+	lh.lostOnCrashCounter, err = lh.meter.Int64Counter("logger_lost_on_crash_spans", metric.WithDescription("Somma totale degli span mai rilasciati recuperati dal write-ahead log"))
+	if err != nil {
+		return err
+	}
+	lh.walErrorCounter, err = lh.meter.Int64Counter("logger_wal_errors", metric.WithDescription("Somma totale delle scritture fallite sul write-ahead log"))
+	if err != nil {
+		return err
+	}
+	lh.droppedRecordsCounter, err = lh.meter.Int64Counter("logger_dropped_span_records", metric.WithDescription("Somma totale dei record scartati perchè il buffer dello span era pieno"))
+	if err != nil {
+		return err
+	}
+	lh.flightDiscardedCounter, err = lh.meter.Int64Counter("logger_flight_recorder_discarded_records", metric.WithDescription("Somma totale dei record di debug e info scartati al rilascio con successo in modalità flight recorder"))
+	if err != nil {
+		return err
+	}
 	lh.activeSpansGauge, err = lh.meter.Int64UpDownCounter("logger_active_spans", metric.WithDescription("Contatore degli span attivi"))
 	if err != nil {
 		return err
@@ -244,6 +353,13 @@ func (lh *LoggerHandler) GetInvalidSpanCounter() Int64CounterLike {
 	return lh.invalidSpanCounter
 }
 
+// GetSinkErrorCounter restituisce il contatore delle scritture fallite sui sink.
+// Parametri: nessuno
+// Ritorna: Int64CounterLike
+func (lh *LoggerHandler) GetSinkErrorCounter() Int64CounterLike {
+	return lh.sinkErrorCounter
+}
+
 // GetActiveSpansGauge restituisce il contatore istantaneo degli span attivi.
 // Parametri: nessuno
 // Ritorna: Int64UpDownCounterLike (può essere nil)
@@ -261,37 +377,127 @@ func (lh *LoggerHandler) GetActiveSpansGauge() Int64UpDownCounterLike {
 //
 // Ritorna: puntatore al nuovo SpanLogger
 func (lh *LoggerHandler) AddSpan(duration time.Duration, tags []string, bufferSize int, level slog.Level) *SpanLogger {
+	return lh.registerSpan(context.Background(), lh.newSpan(duration, tags, bufferSize, level))
+}
+
+// AddSpanContext crea e registra un nuovo SpanLogger a partire da un contesto.
+// Cosa fa: se il contesto trasporta uno SpanContext OpenTelemetry valido lo span ne adotta
+//
+//	il trace ID e registra lo span ID OpenTelemetry come ParentID; con un tracer configurato
+//	lo span di tracing viene avviato come figlio di quello presente nel contesto.
+//
+// Parametri:
+//   - ctx: contesto della richiesta (può contenere uno span di tracing)
+//   - duration: durata del timeout dello span (0 per nessun timeout)
+//   - tags: lista di tag associati allo span
+//   - bufferSize: dimensione del buffer interno dello span
+//   - level: livello minimo di log che causa l'invio immediato
+//
+// Ritorna: puntatore al nuovo SpanLogger
+func (lh *LoggerHandler) AddSpanContext(ctx context.Context, duration time.Duration, tags []string, bufferSize int, level slog.Level) *SpanLogger {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	span := lh.newSpan(duration, tags, bufferSize, level)
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		span.traceID = sc.TraceID().String()
+		span.parentID = sc.SpanID().String()
+		span.sampled = sc.IsSampled()
+		span.traceState = sc.TraceState().String()
+	}
+	return lh.registerSpan(ctx, span)
+}
+
+// AddSpanWithTraceID crea e registra un nuovo SpanLogger appartenente a una trace esistente.
+// Cosa fa: come AddSpan, ma usa il trace ID fornito invece di generarne uno nuovo,
+//
+//	così che span creati in goroutine diverse possano essere raggruppati.
+//
+// Parametri:
+//   - traceID: identificatore della trace (se vuoto ne viene generato uno nuovo)
+//   - duration: durata del timeout dello span (0 per nessun timeout)
+//   - tags: lista di tag associati allo span
+//   - bufferSize: dimensione del buffer interno dello span
+//   - level: livello minimo di log che causa l'invio immediato
+//
+// Ritorna: puntatore al nuovo SpanLogger
+func (lh *LoggerHandler) AddSpanWithTraceID(traceID string, duration time.Duration, tags []string, bufferSize int, level slog.Level) *SpanLogger {
+	span := lh.newSpan(duration, tags, bufferSize, level)
+	span.traceID = traceID
+	return lh.registerSpan(context.Background(), span)
+}
+
+// newSpan crea un nuovo SpanLogger con un id univoco senza registrarlo.
+// Cosa fa: riserva lo spanID nelle mappe interne; il chiamante può impostare padre,
+//
+//	trace e stato di propagazione prima di completare la registrazione con registerSpan.
+//
+// Parametri:
+//   - duration: durata del timeout dello span (0 per nessun timeout)
+//   - tags: lista di tag associati allo span
+//   - bufferSize: dimensione del buffer interno dello span
+//   - level: livello minimo di log che causa l'invio immediato
+//
+// Ritorna: puntatore al nuovo SpanLogger (non ancora registrato)
+func (lh *LoggerHandler) newSpan(duration time.Duration, tags []string, bufferSize int, level slog.Level) *SpanLogger {
 	var spanID string
 	done := false
 	for !done {
 		spanID, done = lh.generateSpanID()
 	}
-
 	span := NewSpanLogger(spanID, duration, tags, bufferSize, lh, level)
+	lh.mu.Lock()
+	span.bufferPolicy = lh.spanBufferPolicy
+	span.mode = lh.spanMode
+	lh.mu.Unlock()
+	return span
+}
+
+// registerSpan completa la registrazione di uno SpanLogger creato con newSpan.
+// Cosa fa: genera il trace ID se assente, avvia lo span di tracing (se configurato),
+//
+//	registra lo span e il legame con il padre e crea il timer per il timeout (se richiesto).
+//
+// Parametri:
+//   - ctx: contesto di partenza per lo span di tracing
+//   - span: SpanLogger creato con newSpan
+//
+// Ritorna: lo stesso SpanLogger, ora registrato
+func (lh *LoggerHandler) registerSpan(ctx context.Context, span *SpanLogger) *SpanLogger {
+	spanID, parentID, duration := span.id, span.parentID, span.timeDuration
+	// un trace ID generato qui può essere sostituito da quello dello span di tracing
+	generated := span.traceID == ""
+	if generated {
+		span.traceID = generateTraceID()
+	}
+	// Durante lo shutdown o dopo Close lo span non viene registrato: i suoi comandi
+	// saranno rifiutati (vedi rejectAfterClose)
+	if atomic.LoadInt32(&lh.closing) == 1 || atomic.LoadInt32(&lh.shuttingDown) == 1 {
+		span.rejected = true
+		lh.releasePlaceholder(spanID)
+		return span
+	}
+	// Se è configurato un tracer avvio anche lo span di tracing
+	lh.startTraceSpan(ctx, span, generated)
+
+	// Registro lo span nel write-ahead log prima che possa inviare comandi
+	lh.walStartSpan(span)
 
 	// Aggiorna lo span nella mappa in modo concorrente-sicuro e crea il timer associato
 	lh.mu.Lock()
 	lh.spans[spanID] = span
+	// registro il figlio presso il padre, se quest'ultimo è ancora aperto
+	if parentID != "" {
+		if _, ok := lh.spans[parentID]; ok {
+			if lh.children[parentID] == nil {
+				lh.children[parentID] = make(map[string]struct{})
+			}
+			lh.children[parentID][spanID] = struct{}{}
+		}
+	}
 	// se è richiesto un timeout > 0 ne creo uno e lo memorizzo
 	if duration > 0 {
-		// incremento il waitgroup dei timer per rappresentare il timer pianificato
-		lh.timersWg.Add(1)
-		// uso AfterFunc per notificare tramite chTimers quando scade
-		idCopy := spanID
-		t := time.AfterFunc(duration, func() {
-			// assicuriamoci di fare Done al termine del callback
-			defer lh.timersWg.Done()
-			// se siamo in fase di chiusura, non inviare
-			if atomic.LoadInt32(&lh.closing) == 1 {
-				return
-			}
-			// invio non bloccante dello spanID sul canale dei timer
-			select {
-			case lh.chTimers <- idCopy:
-			default:
-			}
-		})
-		lh.timeouts[spanID] = t
+		lh.timers.schedule(spanID, time.Now().Add(duration))
 	}
 	lh.mu.Unlock()
 
@@ -320,6 +526,19 @@ func (lh *LoggerHandler) generateSpanID() (string, bool) {
 	return idStr, flag
 }
 
+// generateTraceID genera un nuovo trace ID.
+// Cosa fa: usa un UUID v7 rappresentato come 32 cifre esadecimali (formato compatibile W3C).
+// Parametri: nessuno
+// Ritorna: string
+func generateTraceID() string {
+	id, err := uuid.NewV7()
+	if err != nil {
+		// In caso di errore ripiego su un UUID v4
+		id = uuid.New()
+	}
+	return hex.EncodeToString(id[:])
+}
+
 // addSpanToMaps inserisce un id segnaposto nelle mappe interne per evitare collisioni.
 // Cosa fa: sotto mutex controlla l'esistenza e inserisce un placeholder nil nello map degli span.
 // Parametri: id string
@@ -346,23 +565,42 @@ func (lh *LoggerHandler) addSpanToMaps(id string) bool {
 	return true
 }
 
+// releasePlaceholder toglie il segnaposto riservato da addSpanToMaps a uno span non registrato.
+// Cosa fa: annulla anche l'incremento del contatore degli span attivi fatto da addSpanToMaps.
+// Parametri: id identificatore riservato
+// Ritorna: nulla
+func (lh *LoggerHandler) releasePlaceholder(id string) {
+	lh.mu.Lock()
+	defer lh.mu.Unlock()
+	if span, exists := lh.spans[id]; !exists || span != nil {
+		return
+	}
+	delete(lh.spans, id)
+	if lh.meter != nil && lh.activeSpansGauge != nil {
+		lh.activeSpansGauge.Add(-1)
+	}
+}
+
 // RemoveSpan rimuove lo span con l'id fornito e ferma il timer associato.
 // Parametri: id string
 // Ritorna: nulla
 func (lh *LoggerHandler) RemoveSpan(id string) {
 	lh.mu.Lock()
 	defer lh.mu.Unlock()
-	if _, exists := lh.spans[id]; exists {
-		// fermo ed elimino il timer associato se presente
-		if t, ok := lh.timeouts[id]; ok && t != nil {
-			// Stop restituisce true se il timer è fermato prima che scatti;
-			// in tal caso dobbiamo bilanciare il timersWg chiamando Done()
-			stopped := t.Stop()
-			if stopped {
-				lh.timersWg.Done()
+	if span, exists := lh.spans[id]; exists {
+		// scollego lo span dal padre e dimentico i suoi figli
+		if span != nil && span.parentID != "" {
+			if siblings, ok := lh.children[span.parentID]; ok {
+				delete(siblings, id)
+				if len(siblings) == 0 {
+					delete(lh.children, span.parentID)
+				}
 			}
-			delete(lh.timeouts, id)
 		}
+		delete(lh.children, id)
+
+		// annullo il timeout associato se ancora pianificato
+		lh.timers.cancel(id)
 		delete(lh.spans, id)
 
 		// Controllo se è presente un Meter; le metriche sono opzionali quindi aggiorniamo
@@ -376,31 +614,102 @@ func (lh *LoggerHandler) RemoveSpan(id string) {
 }
 
 // AppendCommand prova ad aggiungere un LogCommand al canale interno.
-// Cosa fa: invio non bloccante sul canale; se pieno incrementa il contatore dei comandi scartati.
+// Cosa fa: OpReleaseFailure e OpTimeout usano prima la corsia prioritaria. Se il canale ha
+//
+//	spazio il comando viene accodato; altrimenti applica la BackpressurePolicy configurata
+//	(predefinita: scarta il comando e incrementa il contatore dei comandi scartati).
+//	OpTimeout non viene mai scartato: attende spazio sulla corsia prioritaria.
+//
+//	Dopo Close il comando è rifiutato (vedi rejectAfterClose).
+//
 // Parametri: cmd LogCommand
-// Ritorna: nulla
-func (lh *LoggerHandler) AppendCommand(cmd LogCommand) {
+// Ritorna: SendAccepted se accodato, SendDroppedFull se scartato, SendRejectedClosed
+//
+//	se il LoggerHandler è chiuso
+func (lh *LoggerHandler) AppendCommand(cmd LogCommand) SendResult {
+	lh.sendMu.RLock()
+	defer lh.sendMu.RUnlock()
+	if lh.closed {
+		lh.rejectAfterClose(cmd)
+		return SendRejectedClosed
+	}
+
+	sh := lh.shardFor(cmd.SpanID)
+	if isPriority(cmd.Op) {
+		select {
+		case sh.priority <- cmd:
+			return SendAccepted
+		default:
+			// Corsia prioritaria piena, uso il canale normale
+		}
+	}
+
+	// Conto il comando tra quelli in coda dello span prima di accodarlo (vedi processPriority)
+	sh.addPending(cmd.SpanID, 1)
 	// Controllo se il canale è pieno
 	select {
-	case lh.channel <- cmd:
+	case sh.channel <- cmd:
 		// Comando aggiunto con successo
-		return
+		return SendAccepted
 	default:
-		// Canale pieno, scarto il comando
+	}
+
+	if cmd.Op == OpTimeout {
+		// Un timeout non viene mai scartato: attendo spazio sulla corsia prioritaria
+		sh.addPending(cmd.SpanID, -1)
+		if !lh.sendPriority(sh, cmd) {
+			lh.rejectAfterClose(cmd)
+			return SendRejectedClosed
+		}
+		return SendAccepted
+	}
+
+	policy, timeout := lh.GetBackpressurePolicy()
+	accepted := false
+	switch policy {
+	case BackpressureDropOldest:
+		lh.sendDropOldest(sh, cmd)
+		accepted = true
+	case BackpressureBlock:
+		accepted = lh.sendBlocking(sh, cmd, policy, 0)
+	case BackpressureBlockWithTimeout:
+		accepted = lh.sendBlocking(sh, cmd, policy, timeout)
+	case BackpressureNeverDropTerminal:
+		if isTerminal(cmd.Op) {
+			accepted = lh.sendBlocking(sh, cmd, policy, 0)
+		}
+	}
+
+	if !accepted {
+		sh.addPending(cmd.SpanID, -1)
+		if lh.isAbandoned() {
+			// Close ha smesso di attendere le goroutine: il comando è rifiutato
+			lh.rejectAfterClose(cmd)
+			return SendRejectedClosed
+		}
+		// Canale pieno, scarto il comando: non va riprodotto dal write-ahead log
+		lh.walDone(cmd)
 		// Controllo se è presente un Meter; le metriche sono opzionali quindi aggiorniamo
 		// solo se `lh.meter` è non-nil.
 		if lh.meter != nil {
-			lh.discardedCounter.Add(1)
+			lh.discardedCounter.Add(1, policyAttr(policy))
 		}
-		return
+		return SendDroppedFull
 	}
+	return SendAccepted
 }
 
 // processCommand processa un singolo LogCommand.
 // Cosa fa: verifica che lo span esista, costruisce la stringa di log e scrive sul writer appropriato.
-// Parametri: cmd LogCommand
+// Parametri:
+//   - sh: shard che elabora il comando
+//   - cmd: LogCommand
+//
 // Ritorna: nulla
-func (lh *LoggerHandler) processCommand(cmd LogCommand) {
+func (lh *LoggerHandler) processCommand(sh *shard, cmd LogCommand) {
+	// Al termine segno il comando come elaborato nel write-ahead log
+	defer lh.walDone(cmd)
+
 	// Controllo che lo SpanID esista
 	cmd, ok := lh.checkSpanExists(cmd)
 	if !ok {
@@ -408,92 +717,219 @@ func (lh *LoggerHandler) processCommand(cmd LogCommand) {
 		return
 	}
 
+	// Se lo span viene rilasciato segnalo gli eventuali figli ancora aperti
+	cmd = lh.reportOpenChildren(cmd)
+
 	// Creo la stringa di log nello string builder
-	lh.createStrLog(cmd)
+	formatErr := lh.createStrLog(&sh.strBuilder, cmd)
 
 	// Scrivo il log in base al tipo di operazione
-	lh.writeToHandler(cmd)
+	writeErr := lh.writeToHandler(sh.strBuilder.String(), cmd)
+	if formatErr != nil || writeErr != nil {
+		lh.handleWriteError(sh.strBuilder.String(), cmd, errors.Join(formatErr, writeErr), lh.deliveredToAnySink(cmd, writeErr))
+	}
+}
+
+// reportOpenChildren aggiunge al comando di rilascio un record con gli span figli ancora aperti.
+// Cosa fa: se il comando chiude lo span e ci sono figli non rilasciati, questi vengono
+//
+//	segnalati come orfani nel log del padre. I figli restano attivi e continuano a
+//	riportare il ParentID nel proprio header quando verranno rilasciati.
+//
+// Parametri: cmd LogCommand
+// Ritorna: LogCommand eventualmente arricchito con il record di warning
+func (lh *LoggerHandler) reportOpenChildren(cmd LogCommand) LogCommand {
+	if cmd.Op == OpLog {
+		return cmd
+	}
+
+	lh.mu.Lock()
+	open := make([]string, 0, len(lh.children[cmd.SpanID]))
+	for id := range lh.children[cmd.SpanID] {
+		open = append(open, id)
+	}
+	lh.mu.Unlock()
+
+	if len(open) == 0 {
+		return cmd
+	}
+	sort.Strings(open)
+
+	// Creo un record di warning con gli span orfani
+	record := slog.NewRecord(time.Now(), slog.LevelWarn, "Span rilasciato con span figli ancora aperti", 0)
+	record.AddAttrs(slog.Any("orphaned_children", open))
+	// Copio i record per non modificare lo slice del chiamante
+	records := make([]slog.Record, 0, len(cmd.Records)+1)
+	cmd.Records = append(append(records, cmd.Records...), record)
+	return cmd
 }
 
 // createStrLog costruisce la rappresentazione testuale dei record contenuti in LogCommand
 // e la pone nello string builder temporaneo.
-// Cosa fa: formatta i record usando l'handler JSON temporaneo.
-// Parametri: cmd LogCommand
-// Ritorna: nulla
-func (lh *LoggerHandler) createStrLog(cmd LogCommand) {
-	// Se non ci sono record, esco
-	if len(cmd.Records) == 0 {
-		return
+// Cosa fa: completa i record (attributi di correlazione e record di errore) e li formatta
+//
+//	con il Formatter del writer di destinazione.
+//
+// Parametri:
+//   - sb: string builder dello shard
+//   - cmd: LogCommand
+//
+// Ritorna: errore di formattazione (l'output parziale resta nello string builder)
+func (lh *LoggerHandler) createStrLog(sb *strings.Builder, cmd LogCommand) error {
+	// Resetto lo string builder, così un comando senza record non riscrive l'output precedente
+	sb.Reset()
+	return lh.formatCommand(sb, cmd)
+}
+
+// formatCommand formatta il comando con il Formatter del writer di destinazione.
+// Cosa fa: un OpLog senza record non produce output; i comandi che rilasciano lo span
+//
+//	sono sempre passati al Formatter, che decide se scrivere qualcosa (ad esempio il
+//	documento di NewJSONDocumentFormatter con esito e durata dello span).
+//
+// Parametri:
+//   - w: destinazione dell'output
+//   - cmd: comando da formattare
+//
+// Ritorna: errore di formattazione
+func (lh *LoggerHandler) formatCommand(w io.Writer, cmd LogCommand) error {
+	// Se un OpLog non ha record, esco
+	if len(cmd.Records) == 0 && cmd.Op == OpLog {
+		return nil
+	}
+
+	// Uso il contesto del comando così che gli handler context-aware lo ricevano
+	ctx := cmd.Ctx
+	if ctx == nil {
+		ctx = context.Background()
 	}
 
-	// Al momento non supporta context.Context, ne creo uno fittizio
-	ctx := context.TODO()
+	formatter := lh.writerFor(cmd.Op).GetFormatter()
+	return formatter.Format(ctx, w, lh.prepareRecords(cmd))
+}
 
-	// Resetto lo string builder
-	lh.strBuilder.Reset()
-	// Aggiungo una riga di separazione allo string builder
-	lh.strBuilder.WriteString("----- OpType: " + cmd.TypeString() + " ----- Span ID: " + cmd.SpanID + " -----\n")
+// prepareRecords restituisce una copia del comando con i record pronti per la formattazione.
+// Cosa fa: aggiunge gli attributi di correlazione a ogni record e, per OpReleaseFailure
+//
+//	OpAborted e OpLostOnCrash, il record che descrive l'errore dello span.
+//
+// Parametri: cmd LogCommand
+// Ritorna: LogCommand con i record completi
+func (lh *LoggerHandler) prepareRecords(cmd LogCommand) LogCommand {
+	records := make([]slog.Record, 0, len(cmd.Records)+1)
 	lastTimestamp := time.Now()
 
 	// Ciclo sui record
 	for _, record := range cmd.Records {
-		// Aggiungo il record al log
 		lastTimestamp = record.Time
-		err := lh.tmpHandler.Handle(ctx, record)
-		if err != nil {
-			// Gestisco l'errore (al momento lo ignoro)
-			continue
-		}
+		records = append(records, withTraceAttrs(cmd, record))
 	}
 
-	if cmd.Op == OpReleaseFailure && cmd.Err != nil {
+	if (cmd.Op == OpReleaseFailure || cmd.Op == OpAborted || cmd.Op == OpLostOnCrash) && cmd.Err != nil {
 		// Creo un record per l'errore
 		errRecord := slog.NewRecord(lastTimestamp, slog.LevelError, "Errore nello span: "+cmd.Err.Error(), 0)
-		// Aggiungo il record al log
-		_ = lh.tmpHandler.Handle(ctx, errRecord)
+		// tipo, catena delle cause e stack come attributi strutturati
+		errRecord.AddAttrs(errorAttrs(cmd.Err, cmd.stack)...)
+		records = append(records, withTraceAttrs(cmd, errRecord))
 	}
 
-	// Aggiungo una riga di chiusura allo string builder
-	lh.strBuilder.WriteString("--------------------")
+	cmd.Records = records
+	return cmd
+}
+
+// writerFor restituisce la configurazione del writer su cui scrivere un'operazione.
+// Parametri: op tipo di operazione
+// Ritorna: logWriter per OpLog e OpReleaseSuccess, errWriter altrimenti
+func (lh *LoggerHandler) writerFor(op OpType) *WriterConfigs {
+	switch op {
+	case OpLog, OpReleaseSuccess:
+		return lh.logWriter
+	default:
+		return lh.errWriter
+	}
+}
+
+// withTraceAttrs restituisce una copia del record con gli attributi di correlazione del comando.
+// Cosa fa: aggiunge trace_id e span_id; span_id è lo span ID OpenTelemetry se lo span
+//
+//	è collegato a uno span di tracing, altrimenti l'id dello SpanLogger. In quest'ultimo caso
+//	aggiunge anche w3c_span_id, il parent-id che i servizi chiamati ricevono nel traceparent.
+//
+// Parametri:
+//   - cmd: LogCommand da cui leggere gli identificatori
+//   - record: record da arricchire
+//
+// Ritorna: slog.Record arricchito
+func withTraceAttrs(cmd LogCommand, record slog.Record) slog.Record {
+	record = record.Clone()
+	if cmd.TraceID != "" {
+		record.AddAttrs(slog.String("trace_id", cmd.TraceID))
+	}
+	spanID := cmd.OtelSpanID
+	if spanID == "" {
+		spanID = cmd.SpanID
+	}
+	if spanID != "" {
+		record.AddAttrs(slog.String("span_id", spanID))
+	}
+	if cmd.W3CSpanID != "" && cmd.W3CSpanID != spanID {
+		record.AddAttrs(slog.String("w3c_span_id", cmd.W3CSpanID))
+	}
+	return record
 }
 
 // writeToHandler scrive la stringa costruita nello string builder sul writer appropriato
 // in base al tipo di operazione contenuta nel LogCommand.
-// Parametri: cmd LogCommand
-// Ritorna: nulla
-func (lh *LoggerHandler) writeToHandler(cmd LogCommand) {
-	var err error
+// Parametri:
+//   - output: output formattato del comando
+//   - cmd: LogCommand
+//
+// Ritorna: error se la scrittura fallisce, altrimenti nil
+func (lh *LoggerHandler) writeToHandler(output string, cmd LogCommand) error {
 	switch cmd.Op {
 	case OpLog:
-		err = lh.processOpLog(cmd.SpanID)
-		if err != nil {
-			return
-		}
+		return lh.processOpLog(output)
 	case OpReleaseSuccess:
-		err = lh.processOpSuccess(cmd.SpanID)
-		if err != nil {
-			return
-		}
+		return lh.processOpSuccess(cmd.SpanID, output)
 	case OpReleaseFailure:
-		err = lh.processOpFailure(cmd.SpanID)
-		if err != nil {
-			return
-		}
+		return lh.processOpFailure(cmd.SpanID, output)
 	case OpTimeout:
-		err = lh.processOpTimeout(cmd.SpanID)
-		if err != nil {
-			return
-		}
+		return lh.processOpTimeout(cmd.SpanID, output)
+	case OpAborted:
+		return lh.processOpTimeout(cmd.SpanID, output)
+	}
+	return nil
+}
+
+// writeOutput scrive l'output formattato su tutti i sink del writer.
+// Cosa fa: ogni sink riceve l'output anche se altri falliscono; le scritture fallite
+//
+//	vengono contate nella metrica logger_sink_errors.
+//
+// Parametri:
+//   - wc: configurazione del writer di destinazione
+//   - output: output formattato
+//
+// Ritorna: errore combinato dei sink falliti, altrimenti nil
+func (lh *LoggerHandler) writeOutput(wc *WriterConfigs, output string) error {
+	_, err := io.WriteString(wc.GetMultiWriter(), output)
+	if err != nil && lh.meter != nil {
+		lh.sinkErrorCounter.Add(int64(max(len(SinkErrors(err)), 1)))
 	}
-	return
+	return err
 }
 
 // processOpLog gestisce la scrittura di un normale log (OpLog).
-// Parametri: _ string (non usato, mantenuto per compatibilità futura)
+// Parametri: output string (output formattato del comando)
 // Ritorna: error se la scrittura fallisce, altrimenti nil
-func (lh *LoggerHandler) processOpLog(_ string) error {
+func (lh *LoggerHandler) processOpLog(output string) error {
+	// Se l'output è vuoto, non scrivo nulla
+	if len(output) == 0 {
+		return nil
+	}
+
 	// Scrivo sul log handler la stringa presente nello string builder
-	_, err := fmt.Fprintln(lh.logWriter.GetMultiWriter(), lh.strBuilder.String())
+	err := lh.writeOutput(lh.logWriter, output)
 	if err != nil {
 		return err
 	}
@@ -502,9 +938,12 @@ func (lh *LoggerHandler) processOpLog(_ string) error {
 
 // processOpFailure gestisce la chiusura dello span con failure (OpReleaseFailure).
 // Cosa fa: aggiorna metriche, rimuove lo span e scrive il log di errore.
-// Parametri: spanId string
+// Parametri:
+//   - spanId: identificatore dello span
+//   - output: output formattato del comando
+//
 // Ritorna: error se la scrittura fallisce, altrimenti nil
-func (lh *LoggerHandler) processOpFailure(spanId string) error {
+func (lh *LoggerHandler) processOpFailure(spanId string, output string) error {
 	// Controllo se è presente un Meter; le metriche sono opzionali quindi aggiorniamo
 	// solo se `lh.meter` è non-nil`.
 	if lh.meter != nil {
@@ -517,7 +956,12 @@ func (lh *LoggerHandler) processOpFailure(spanId string) error {
 	// Rimuovo gli span completati con successo dalla mappa
 	lh.RemoveSpan(spanId)
 
-	_, err := fmt.Fprintln(lh.errWriter.GetMultiWriter(), lh.strBuilder.String())
+	// Se l'output è vuoto, non scrivo nulla
+	if len(output) == 0 {
+		return nil
+	}
+
+	err := lh.writeOutput(lh.errWriter, output)
 	if err != nil {
 		return err
 	}
@@ -527,9 +971,12 @@ func (lh *LoggerHandler) processOpFailure(spanId string) error {
 
 // processOpSuccess gestisce la chiusura dello span con successo (OpReleaseSuccess).
 // Cosa fa: aggiorna metriche, rimuove lo span e scrive il log se presente.
-// Parametri: spanId string
+// Parametri:
+//   - spanId: identificatore dello span
+//   - output: output formattato del comando
+//
 // Ritorna: error se la scrittura fallisce, altrimenti nil
-func (lh *LoggerHandler) processOpSuccess(spanId string) error {
+func (lh *LoggerHandler) processOpSuccess(spanId string, output string) error {
 	// Controllo se è presente un Meter; le metriche sono opzionali quindi aggiorniamo
 	// solo se `lh.meter` è non-nil`.
 	if lh.meter != nil {
@@ -542,24 +989,28 @@ func (lh *LoggerHandler) processOpSuccess(spanId string) error {
 	// Rimuovo gli span completati con successo dalla mappa
 	lh.RemoveSpan(spanId)
 
-	// Se la lunghezza dello string builder è zero, non scrivo nulla
-	if lh.strBuilder.Len() == 0 {
+	// Se l'output è vuoto, non scrivo nulla
+	if len(output) == 0 {
 		return nil
 	}
 
 	// Scrivo sul log handler la stringa presente nello string builder
-	_, err := fmt.Fprintln(lh.logWriter.GetMultiWriter(), lh.strBuilder.String())
+	err := lh.writeOutput(lh.logWriter, output)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-// processOpTimeout gestisce la chiusura dello span per timeout (OpTimeout).
+// processOpTimeout gestisce la chiusura dello span per timeout (OpTimeout) o per
+// interruzione allo shutdown (OpAborted).
 // Cosa fa: aggiorna metriche, rimuove lo span e scrive il log di errore.
-// Parametri: spanId string
+// Parametri:
+//   - spanId: identificatore dello span
+//   - output: output formattato del comando
+//
 // Ritorna: error se la scrittura fallisce, altrimenti nil
-func (lh *LoggerHandler) processOpTimeout(spanId string) error {
+func (lh *LoggerHandler) processOpTimeout(spanId string, output string) error {
 	// Controllo se è presente un Meter; le metriche sono opzionali quindi aggiorniamo
 	// solo se `lh.meter` è non-nil`.
 	if lh.meter != nil {
@@ -572,7 +1023,12 @@ func (lh *LoggerHandler) processOpTimeout(spanId string) error {
 	// Rimuovo gli span completati con successo dalla mappa
 	lh.RemoveSpan(spanId)
 
-	_, err := fmt.Fprintln(lh.errWriter.GetMultiWriter(), lh.strBuilder.String())
+	// Se l'output è vuoto, non scrivo nulla
+	if len(output) == 0 {
+		return nil
+	}
+
+	err := lh.writeOutput(lh.errWriter, output)
 	if err != nil {
 		return err
 	}
@@ -594,6 +1050,11 @@ func (lh *LoggerHandler) checkSpanExists(cmd LogCommand) (LogCommand, bool) {
 	_, exists := lh.spans[cmd.SpanID]
 	lh.mu.Unlock()
 
+	if !exists && cmd.Op == OpAborted {
+		// Lo span è stato rilasciato mentre Shutdown lo interrompeva: niente da segnalare
+		return cmd, false
+	}
+
 	if !exists {
 		// Lo SpanID non esiste
 		// Controllo se è presente un Meter; le metriche sono opzionali quindi aggiorniamo
@@ -618,37 +1079,79 @@ func (lh *LoggerHandler) checkSpanExists(cmd LogCommand) (LogCommand, bool) {
 }
 
 // Close ferma tutti i timer, chiude i canali e aspetta la terminazione delle goroutine.
+// Cosa fa: al termine svuota e chiude i sink dei writer (una sola volta se log ed errori
+//
+//	condividono lo stesso WriterConfigs). L'attesa è limitata dal timeout di chiusura
+//	(vedi SetCloseTimeout): scaduto il timeout gli invii ancora bloccati sono rifiutati e,
+//	se una goroutine è ferma su un sink, Close ritorna senza chiudere i sink e senza
+//	rimuovere il write-ahead log (vedi CloseError).
+//
 // Parametri: nessuno
 // Ritorna: nulla
 func (lh *LoggerHandler) Close() {
 	lh.closeOnce.Do(func() {
-		// segnalo che stiamo chiudendo per le callback dei timer
+		// segnalo che stiamo chiudendo allo scheduler dei timeout
 		atomic.StoreInt32(&lh.closing, 1)
 
-		// fermo tutti i timer e svuoto la mappa
-		lh.mu.Lock()
-		for id, t := range lh.timeouts {
-			if t != nil {
-				stopped := t.Stop()
-				if stopped {
-					// se abbiamo fermato il timer prima che scattasse, bilanciamo il waitgroup
-					lh.timersWg.Done()
-				}
-			}
-			delete(lh.timeouts, id)
+		// scaduto il timeout di chiusura smetto di attendere invii e goroutine
+		if timeout := lh.GetCloseTimeout(); timeout > 0 {
+			abandonTimer := time.AfterFunc(timeout, func() { close(lh.abandon) })
+			defer abandonTimer.Stop()
 		}
-		lh.timeouts = make(map[string]*time.Timer)
-		lh.mu.Unlock()
 
-		// aspettiamo che eventuali callback in esecuzione terminino
-		lh.timersWg.Wait()
+		// fermo lo scheduler: al ritorno nessun timeout è in esecuzione o verrà notificato
+		lh.timers.close()
 
-		// ora è sicuro chiudere il canale dei timer (nessun callback invierà)
-		close(lh.chTimers)
-
-		// segnalo alle goroutine di fermarsi
-		close(lh.channel)
+		// segnalo alle goroutine di fermarsi; attendo gli invii in corso così che
+		// nessuno invii su un canale chiuso
+		lh.sendMu.Lock()
+		lh.closed = true
+		for _, sh := range lh.shards {
+			close(sh.priority)
+			close(sh.channel)
+		}
+		lh.sendMu.Unlock()
 		// aspetto che le goroutine finiscano
-		lh.wg.Wait()
+		if !lh.waitWorkers() {
+			// una goroutine è ancora ferma su un sink: non posso chiuderli
+			lh.mu.Lock()
+			lh.closeErr = ErrCloseTimeout
+			lh.mu.Unlock()
+			return
+		}
+
+		// nessuna scrittura è più in corso: chiudo i sink
+		_ = lh.logWriter.Close()
+		if lh.errWriter != lh.logWriter {
+			_ = lh.errWriter.Close()
+		}
+		_ = lh.SetDeadLetterFile("")
+		// chiusura ordinata: il write-ahead log non serve più
+		if lh.wal != nil {
+			_ = lh.wal.close()
+		}
 	})
 }
+
+// waitWorkers attende la terminazione delle goroutine di elaborazione.
+// Parametri: nessuno
+// Ritorna: false se il timeout di chiusura è scaduto prima che terminassero
+func (lh *LoggerHandler) waitWorkers() bool {
+	done := make(chan struct{})
+	go func() {
+		lh.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-lh.abandon:
+		// se sono terminate nello stesso istante preferisco la chiusura ordinata
+		select {
+		case <-done:
+			return true
+		default:
+			return false
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package loggerhandler
+
+// priorityCapacity calcola la capacità riservata alla corsia prioritaria.
+// Cosa fa: riserva un quarto del buffer dei comandi (almeno 1) a OpReleaseFailure e OpTimeout.
+// Parametri: bufferSize dimensione del canale dei comandi
+// Ritorna: int
+func priorityCapacity(bufferSize int) int {
+	return max(bufferSize/4, 1)
+}
+
+// isPriority indica se l'operazione viaggia sulla corsia prioritaria.
+// Parametri: op tipo di operazione
+// Ritorna: bool
+func isPriority(op OpType) bool {
+	return op == OpReleaseFailure || op == OpTimeout
+}
+
+// run è il ciclo della goroutine che elabora i comandi di uno shard.
+// Cosa fa: preferisce sempre la corsia prioritaria; quando è vuota attende un comando da
+//
+//	una qualsiasi delle due corsie. Un comando prioritario non supera mai i comandi dello
+//	stesso span già accodati sul canale normale: viene rimandato finché non sono elaborati.
+//	Termina quando entrambi i canali sono chiusi e vuoti.
+//
+// Parametri: sh shard da elaborare
+// Ritorna: nulla
+func (lh *LoggerHandler) run(sh *shard) {
+	priority, normal := sh.priority, sh.channel
+	for priority != nil || normal != nil {
+		// Prima svuoto la corsia prioritaria
+		if priority != nil {
+			select {
+			case cmd, ok := <-priority:
+				if !ok {
+					priority = nil
+				} else {
+					lh.processPriority(sh, cmd)
+				}
+				continue
+			default:
+			}
+		}
+
+		select {
+		case cmd, ok := <-priority:
+			if !ok {
+				priority = nil
+				continue
+			}
+			lh.processPriority(sh, cmd)
+		case cmd, ok := <-normal:
+			if !ok {
+				normal = nil
+				continue
+			}
+			lh.processCommand(sh, cmd)
+			sh.addPending(cmd.SpanID, -1)
+			lh.processDeferred(sh)
+		}
+	}
+	// i canali sono chiusi: elaboro i comandi rimasti in attesa
+	for id, cmds := range sh.deferred {
+		for _, cmd := range cmds {
+			lh.processCommand(sh, cmd)
+		}
+		delete(sh.deferred, id)
+	}
+}
+
+// processPriority elabora un comando ricevuto dalla corsia prioritaria.
+// Cosa fa: se sul canale normale ci sono ancora comandi dello stesso span il comando
+//
+//	viene rimandato, così da non superarli.
+//
+// Parametri:
+//   - sh: shard che elabora il comando
+//   - cmd: comando prioritario
+//
+// Ritorna: nulla
+func (lh *LoggerHandler) processPriority(sh *shard, cmd LogCommand) {
+	if _, waiting := sh.deferred[cmd.SpanID]; waiting || sh.pendingFor(cmd.SpanID) > 0 {
+		sh.deferred[cmd.SpanID] = append(sh.deferred[cmd.SpanID], cmd)
+		return
+	}
+	lh.processCommand(sh, cmd)
+}
+
+// processDeferred elabora i comandi prioritari degli span che non hanno più comandi in coda.
+// Parametri: sh shard che elabora i comandi
+// Ritorna: nulla
+func (lh *LoggerHandler) processDeferred(sh *shard) {
+	for id, cmds := range sh.deferred {
+		if sh.pendingFor(id) > 0 {
+			continue
+		}
+		delete(sh.deferred, id)
+		for _, cmd := range cmds {
+			lh.processCommand(sh, cmd)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package loggerhandler
+
+import (
+	"errors"
+)
+
+var (
+	// ErrCommandDropped indica un comando scartato perché il buffer dei comandi era pieno.
+	ErrCommandDropped = errors.New("comando scartato: buffer dei comandi pieno")
+	// ErrHandlerClosed indica un comando rifiutato perché il LoggerHandler è chiuso.
+	ErrHandlerClosed = errors.New("comando rifiutato: LoggerHandler chiuso")
+)
+
+// SendResult è l'esito dell'invio di un LogCommand al LoggerHandler.
+type SendResult int
+
+const (
+	// SendAccepted indica che il comando è stato accodato (o che nessun invio è fallito).
+	SendAccepted SendResult = iota
+	// SendDroppedFull indica che il comando è stato scartato perché il buffer era pieno.
+	SendDroppedFull
+	// SendRejectedClosed indica che il comando è stato rifiutato perché il LoggerHandler è chiuso.
+	SendRejectedClosed
+)
+
+// String restituisce il nome dell'esito.
+// Parametri: nessuno
+// Ritorna: string
+func (r SendResult) String() string {
+	switch r {
+	case SendAccepted:
+		return "Accepted"
+	case SendDroppedFull:
+		return "DroppedFull"
+	case SendRejectedClosed:
+		return "RejectedClosed"
+	default:
+		return "Unknown"
+	}
+}
+
+// Err restituisce l'errore corrispondente all'esito.
+// Parametri: nessuno
+// Ritorna: nil per SendAccepted, ErrCommandDropped o ErrHandlerClosed altrimenti
+func (r SendResult) Err() error {
+	switch r {
+	case SendDroppedFull:
+		return ErrCommandDropped
+	case SendRejectedClosed:
+		return ErrHandlerClosed
+	default:
+		return nil
+	}
+}
+
+// LastSendResult restituisce l'esito dell'ultimo comando inviato dallo span.
+// Cosa fa: permette ai percorsi critici (audit, pagamenti) di accorgersi di un log perso
+//
+//	e reagire, ad esempio scrivendo in modo sincrono.
+//
+// Parametri: nessuno
+// Ritorna: SendResult (SendAccepted se lo span non ha ancora inviato comandi)
+func (sl *SpanLogger) LastSendResult() SendResult {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	return sl.lastSend
+}
+
+// LastSendError restituisce l'errore dell'ultimo comando inviato dallo span.
+// Parametri: nessuno
+// Ritorna: nil se accettato, ErrCommandDropped o ErrHandlerClosed altrimenti
+func (sl *SpanLogger) LastSendError() error {
+	return sl.LastSendResult().Err()
+}
@@ -0,0 +1,99 @@
+package loggerhandler
+
+import (
+	"hash/fnv"
+	"strings"
+	"sync"
+)
+
+// HandlerOptions raccoglie le opzioni facoltative di NewLoggerHandlerWithOptions.
+type HandlerOptions struct {
+	// Shards è il numero di goroutine che elaborano i comandi (valori < 1 equivalgono a 1).
+	// Ogni span è assegnato a uno shard in base all'hash del suo id, così l'ordine dei
+	// comandi di uno stesso span è preservato.
+	Shards int
+	// WALDir, se valorizzato, abilita il write-ahead log in questa directory: span, record
+	// e comandi vengono accodati a un segmento prima di proseguire, e all'avvio i segmenti
+	// lasciati da un crash vengono riprodotti emettendo gli span mai rilasciati.
+	WALDir string
+	// WALSegmentSize è la dimensione in byte oltre la quale il segmento del write-ahead log
+	// viene ruotato (<= 0 per il valore predefinito di 4 MiB). I segmenti ruotati sono
+	// eliminati quando tutti i loro span sono stati rilasciati.
+	WALSegmentSize int64
+}
+
+// shard è una goroutine di elaborazione con le proprie code e il proprio buffer di formattazione.
+type shard struct {
+	// canale dei comandi dello shard
+	channel chan LogCommand
+	// corsia prioritaria riservata a OpReleaseFailure e OpTimeout
+	priority chan LogCommand
+	// buffer in cui il Formatter scrive l'output del comando in elaborazione
+	strBuilder strings.Builder
+
+	// pendingMu protegge pending
+	pendingMu sync.Mutex
+	// comandi di ogni span accodati sul canale normale e non ancora elaborati
+	pending map[string]int
+	// comandi prioritari in attesa che gli OpLog precedenti dello stesso span siano
+	// elaborati (usato solo dalla goroutine dello shard)
+	deferred map[string][]LogCommand
+}
+
+// newShard crea uno shard con le code dimensionate su bufferSize.
+// Parametri: bufferSize dimensione del canale dei comandi
+// Ritorna: *shard
+func newShard(bufferSize int) *shard {
+	return &shard{
+		channel: make(chan LogCommand, bufferSize),
+		// capacità riservata ai comandi di failure e timeout
+		priority: make(chan LogCommand, priorityCapacity(bufferSize)),
+		pending:  make(map[string]int),
+		deferred: make(map[string][]LogCommand),
+	}
+}
+
+// addPending aggiorna il numero di comandi dello span accodati sul canale normale.
+// Cosa fa: va chiamato con +1 prima di accodare un comando (così il worker lo vede prima
+//
+//	di ricevere un comando prioritario successivo) e con -1 quando il comando viene
+//	elaborato, scartato o tolto dalla coda.
+//
+// Parametri:
+//   - spanID: identificatore dello span
+//   - delta: variazione del conteggio
+//
+// Ritorna: il conteggio aggiornato
+func (sh *shard) addPending(spanID string, delta int) int {
+	sh.pendingMu.Lock()
+	defer sh.pendingMu.Unlock()
+	n := sh.pending[spanID] + delta
+	if n <= 0 {
+		delete(sh.pending, spanID)
+		return 0
+	}
+	sh.pending[spanID] = n
+	return n
+}
+
+// pendingFor restituisce il numero di comandi dello span accodati sul canale normale.
+// Parametri: spanID identificatore dello span
+// Ritorna: int
+func (sh *shard) pendingFor(spanID string) int {
+	sh.pendingMu.Lock()
+	defer sh.pendingMu.Unlock()
+	return sh.pending[spanID]
+}
+
+// shardFor restituisce lo shard a cui è assegnato lo span.
+// Cosa fa: usa l'hash FNV-1a dell'id, così tutti i comandi di uno span vanno sullo stesso shard.
+// Parametri: spanID identificatore dello span
+// Ritorna: *shard
+func (lh *LoggerHandler) shardFor(spanID string) *shard {
+	if len(lh.shards) == 1 {
+		return lh.shards[0]
+	}
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(spanID))
+	return lh.shards[h.Sum32()%uint32(len(lh.shards))]
+}
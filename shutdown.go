@@ -0,0 +1,180 @@
+package loggerhandler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// shutdownPollInterval è l'intervallo con cui Shutdown controlla gli span ancora aperti.
+	shutdownPollInterval = 10 * time.Millisecond
+	// defaultCloseTimeout è l'attesa massima predefinita di Close (vedi SetCloseTimeout).
+	defaultCloseTimeout = 10 * time.Second
+)
+
+var (
+	// ErrAbortedAtShutdown è l'errore degli span chiusi forzatamente da Shutdown.
+	ErrAbortedAtShutdown = errors.New("span interrotto allo shutdown")
+	// ErrCloseTimeout indica che Close ha smesso di attendere le goroutine di elaborazione.
+	ErrCloseTimeout = errors.New("chiusura: le goroutine di elaborazione non hanno terminato entro il timeout")
+)
+
+// SetCloseTimeout imposta l'attesa massima di Close (e quindi di Shutdown dopo la scadenza del contesto).
+// Cosa fa: scaduto il timeout gli invii bloccati in attesa di spazio sono rifiutati (vedi
+//
+//	rejectAfterClose) e Close ritorna anche se una goroutine è ferma su un sink bloccato;
+//	in quel caso i sink non vengono chiusi e CloseError restituisce ErrCloseTimeout.
+//	Un valore <= 0 attende senza limite.
+//
+// Parametri: timeout attesa massima (predefinita 10s)
+// Ritorna: nulla
+func (lh *LoggerHandler) SetCloseTimeout(timeout time.Duration) {
+	lh.mu.Lock()
+	defer lh.mu.Unlock()
+	lh.closeTimeout = timeout
+}
+
+// GetCloseTimeout restituisce l'attesa massima di Close.
+// Parametri: nessuno
+// Ritorna: time.Duration
+func (lh *LoggerHandler) GetCloseTimeout() time.Duration {
+	lh.mu.Lock()
+	defer lh.mu.Unlock()
+	return lh.closeTimeout
+}
+
+// CloseError restituisce l'esito della chiusura.
+// Parametri: nessuno
+// Ritorna: ErrCloseTimeout se Close ha smesso di attendere le goroutine, altrimenti nil
+func (lh *LoggerHandler) CloseError() error {
+	lh.mu.Lock()
+	defer lh.mu.Unlock()
+	return lh.closeErr
+}
+
+// isAbandoned indica se Close ha smesso di attendere invii e goroutine.
+// Parametri: nessuno
+// Ritorna: bool
+func (lh *LoggerHandler) isAbandoned() bool {
+	select {
+	case <-lh.abandon:
+		return true
+	default:
+		return false
+	}
+}
+
+// Shutdown chiude il LoggerHandler in modo ordinato.
+// Cosa fa:
+//   - smette di accettare nuovi span (i loro comandi sono rifiutati come dopo Close);
+//   - attende, fino alla scadenza di ctx, che gli span aperti vengano rilasciati;
+//   - emette gli span ancora aperti con i record in buffer ed esito "aborted" (OpAborted);
+//   - chiude il LoggerHandler e i sink come Close, attendendo al più il timeout di chiusura.
+//
+// Parametri: ctx contesto che limita l'attesa degli span aperti
+// Ritorna: nil se tutti gli span sono stati rilasciati, altrimenti un errore che
+//
+//	avvolge ctx.Err() con il numero di span interrotti ed eventualmente ErrCloseTimeout
+func (lh *LoggerHandler) Shutdown(ctx context.Context) error {
+	atomic.StoreInt32(&lh.shuttingDown, 1)
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	var err error
+	for len(lh.openSpans()) > 0 && err == nil {
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+		case <-ticker.C:
+		}
+	}
+
+	if err != nil {
+		remaining := lh.openSpans()
+		for _, span := range remaining {
+			lh.abortSpan(ctx, span)
+		}
+		if len(remaining) > 0 {
+			err = fmt.Errorf("shutdown: %d span interrotti: %w", len(remaining), err)
+		} else {
+			err = nil
+		}
+	}
+
+	lh.Close()
+	if closeErr := lh.CloseError(); closeErr != nil {
+		err = errors.Join(err, closeErr)
+	}
+	return err
+}
+
+// openSpans restituisce gli span ancora registrati, ordinati per istante di creazione.
+// Parametri: nessuno
+// Ritorna: []*SpanLogger
+func (lh *LoggerHandler) openSpans() []*SpanLogger {
+	lh.mu.Lock()
+	spans := make([]*SpanLogger, 0, len(lh.spans))
+	for _, span := range lh.spans {
+		if span != nil {
+			spans = append(spans, span)
+		}
+	}
+	lh.mu.Unlock()
+	sort.Slice(spans, func(i, j int) bool {
+		return spans[i].startTime.Before(spans[j].startTime)
+	})
+	return spans
+}
+
+// abortSpan emette uno span ancora aperto con esito OpAborted.
+// Cosa fa: aggiunge un record di warning ai record in buffer e accoda il comando
+//
+//	indipendentemente dalla BackpressurePolicy, attendendo spazio nel canale al più
+//	fino alla scadenza di ctx; se il canale resta pieno il comando è rifiutato come
+//	dopo Close (vedi rejectAfterClose) e scritto sul fallback, se configurato.
+//
+// Parametri:
+//   - ctx: contesto di Shutdown che limita l'attesa
+//   - span: span da chiudere
+//
+// Ritorna: nulla
+func (lh *LoggerHandler) abortSpan(ctx context.Context, span *SpanLogger) {
+	record := slog.NewRecord(time.Now(), slog.LevelWarn, "Span interrotto allo shutdown", 0)
+	// tengo il lock dello span fino all'accodamento, così il comando segue quelli già inviati
+	span.mu.Lock()
+	defer span.mu.Unlock()
+	span.appendTerminalRecord(record)
+	cmd := span.buildLogCmd(context.Background(), OpAborted, ErrAbortedAtShutdown)
+	span.buffer = []slog.Record{}
+	span.endTraceSpan(cmd)
+	lh.walCommand(cmd)
+
+	lh.sendMu.RLock()
+	defer lh.sendMu.RUnlock()
+	if lh.closed {
+		lh.rejectAfterClose(cmd)
+		return
+	}
+	sh := lh.shardFor(cmd.SpanID)
+	sh.addPending(cmd.SpanID, 1)
+	// provo prima senza attendere: il contesto di Shutdown è di solito già scaduto
+	select {
+	case sh.channel <- cmd:
+		return
+	default:
+	}
+	select {
+	case sh.channel <- cmd:
+	case <-ctx.Done():
+		sh.addPending(cmd.SpanID, -1)
+		lh.rejectAfterClose(cmd)
+	case <-lh.abandon:
+		sh.addPending(cmd.SpanID, -1)
+		lh.rejectAfterClose(cmd)
+	}
+}
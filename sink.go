@@ -0,0 +1,302 @@
+package loggerhandler
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// ErrSinkClosed indica una scrittura su un Sink già chiuso.
+var ErrSinkClosed = errors.New("sink chiuso")
+
+// Sink è una destinazione dell'output formattato di un WriterConfigs.
+// Cosa fa: riceve in una singola Write l'output completo di uno span (o di un record,
+//
+//	a seconda del Formatter) e permette di svuotare i buffer e rilasciare le risorse.
+//	Console, file, rete, syslog o memoria possono essere aggiunti senza modificare il pacchetto.
+//
+// Parametri: nessuno
+// Ritorna: nessuna (è un'interfaccia)
+type Sink interface {
+	// Write scrive l'output formattato.
+	// Parametri:
+	//  - p: byte da scrivere
+	// Ritorna: numero di byte scritti ed errore
+	Write(p []byte) (int, error)
+	// Flush svuota eventuali buffer interni verso la destinazione.
+	// Parametri: nessuno
+	// Ritorna: errore in caso di fallimento
+	Flush() error
+	// Close svuota i buffer e rilascia le risorse; chiamate successive non devono fallire.
+	// Parametri: nessuno
+	// Ritorna: errore in caso di fallimento
+	Close() error
+}
+
+// SinkError descrive il fallimento della scrittura su un singolo Sink.
+// Cosa fa: la scrittura su più destinazioni restituisce un SinkError per ogni sink fallito,
+//
+//	combinati con errors.Join; gli altri sink ricevono comunque l'output.
+//
+// Parametri: nessuno
+// Ritorna: nessuna (è una struttura)
+type SinkError struct {
+	Sink Sink  // destinazione che ha fallito
+	Err  error // errore restituito dalla destinazione
+}
+
+// Error restituisce la descrizione dell'errore.
+// Parametri: nessuno
+// Ritorna: string
+func (e *SinkError) Error() string {
+	return fmt.Sprintf("scrittura sul sink %T fallita: %v", e.Sink, e.Err)
+}
+
+// Unwrap restituisce l'errore originale della destinazione.
+// Parametri: nessuno
+// Ritorna: error
+func (e *SinkError) Unwrap() error {
+	return e.Err
+}
+
+// SinkErrors estrae i SinkError contenuti in un errore di scrittura.
+// Parametri: err errore restituito dal writer di un WriterConfigs
+// Ritorna: []*SinkError (vuoto se err non contiene errori dei sink)
+func SinkErrors(err error) []*SinkError {
+	if err == nil {
+		return nil
+	}
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		var out []*SinkError
+		for _, e := range joined.Unwrap() {
+			out = append(out, SinkErrors(e)...)
+		}
+		return out
+	}
+	var sinkErr *SinkError
+	if errors.As(err, &sinkErr) {
+		return []*SinkError{sinkErr}
+	}
+	return nil
+}
+
+// fanOutWriter scrive su ogni sink in modo indipendente.
+// A differenza di io.MultiWriter non si ferma al primo errore. Le scritture sono
+// serializzate da mu, così l'output di uno span non si mescola con quello di altri shard.
+type fanOutWriter struct {
+	mu      *sync.Mutex
+	sinks   []Sink
+	onError func(sink Sink, err error)
+}
+
+// Write scrive p su tutti i sink e raccoglie gli errori di ciascuno.
+// Parametri: p byte da scrivere
+// Ritorna: len(p) ed errore combinato dei SinkError (nil se tutti i sink hanno scritto)
+func (fw *fanOutWriter) Write(p []byte) (int, error) {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	var errs []error
+	for _, sink := range fw.sinks {
+		n, err := sink.Write(p)
+		if err == nil && n != len(p) {
+			err = io.ErrShortWrite
+		}
+		if err == nil {
+			continue
+		}
+		if fw.onError != nil {
+			fw.onError(sink, err)
+		}
+		errs = append(errs, &SinkError{Sink: sink, Err: err})
+	}
+	return len(p), errors.Join(errs...)
+}
+
+// consoleSink scrive sullo standard output; Flush e Close non hanno effetto.
+type consoleSink struct{}
+
+// NewConsoleSink crea un Sink che scrive su os.Stdout.
+// Parametri: nessuno
+// Ritorna: Sink
+func NewConsoleSink() Sink {
+	return consoleSink{}
+}
+
+// Write scrive su os.Stdout.
+// Parametri: p byte da scrivere
+// Ritorna: numero di byte scritti ed errore
+func (consoleSink) Write(p []byte) (int, error) {
+	return os.Stdout.Write(p)
+}
+
+// Flush non fa nulla: os.Stdout non ha buffer.
+// Parametri: nessuno
+// Ritorna: nil
+func (consoleSink) Flush() error {
+	return nil
+}
+
+// Close non fa nulla: os.Stdout non viene mai chiuso.
+// Parametri: nessuno
+// Ritorna: nil
+func (consoleSink) Close() error {
+	return nil
+}
+
+// fileSink scrive su un file con rotazione gestita da lumberjack.
+type fileSink struct {
+	logger *lumberjack.Logger
+}
+
+// NewFileSink crea un Sink su file con rotazione.
+// Parametri:
+//   - fileLocation: percorso del file di log
+//   - fileMaxSize: dimensione massima in MB prima della rotazione
+//   - fileMaxBackups: numero di file di backup da mantenere
+//   - compress: abilita o meno la compressione dei file di backup
+//
+// Ritorna: Sink
+func NewFileSink(fileLocation string, fileMaxSize int, fileMaxBackups int, compress bool) Sink {
+	return &fileSink{
+		logger: &lumberjack.Logger{
+			Filename:   fileLocation,
+			MaxSize:    fileMaxSize,
+			MaxBackups: fileMaxBackups,
+			Compress:   compress,
+		},
+	}
+}
+
+// Write scrive sul file corrente (aprendolo o ruotandolo se necessario).
+// Parametri: p byte da scrivere
+// Ritorna: numero di byte scritti ed errore
+func (fs *fileSink) Write(p []byte) (int, error) {
+	return fs.logger.Write(p)
+}
+
+// Flush non fa nulla: lumberjack scrive direttamente sul file.
+// Parametri: nessuno
+// Ritorna: nil
+func (fs *fileSink) Flush() error {
+	return nil
+}
+
+// Close chiude il file corrente; una Write successiva lo riaprirebbe.
+// Parametri: nessuno
+// Ritorna: errore di chiusura del file
+func (fs *fileSink) Close() error {
+	return fs.logger.Close()
+}
+
+// writerSink adatta un io.Writer qualsiasi all'interfaccia Sink.
+type writerSink struct {
+	w io.Writer
+}
+
+// NewWriterSink crea un Sink a partire da un io.Writer.
+// Cosa fa: Flush usa il metodo Flush() error o Sync() error del writer se presente;
+//
+//	Close chiama Flush e poi Close del writer se implementa io.Closer.
+//
+// Parametri: w writer di destinazione
+// Ritorna: Sink
+func NewWriterSink(w io.Writer) Sink {
+	return &writerSink{w: w}
+}
+
+// Write scrive sul writer incapsulato.
+// Parametri: p byte da scrivere
+// Ritorna: numero di byte scritti ed errore
+func (ws *writerSink) Write(p []byte) (int, error) {
+	return ws.w.Write(p)
+}
+
+// Flush svuota il writer incapsulato se lo supporta.
+// Parametri: nessuno
+// Ritorna: errore del writer
+func (ws *writerSink) Flush() error {
+	switch f := ws.w.(type) {
+	case interface{ Flush() error }:
+		return f.Flush()
+	case interface{ Sync() error }:
+		return f.Sync()
+	}
+	return nil
+}
+
+// Close svuota e chiude il writer incapsulato se lo supporta.
+// Parametri: nessuno
+// Ritorna: errore del writer
+func (ws *writerSink) Close() error {
+	err := ws.Flush()
+	if c, ok := ws.w.(io.Closer); ok {
+		err = errors.Join(err, c.Close())
+	}
+	return err
+}
+
+// MemorySink conserva in memoria l'output ricevuto; utile per test e diagnostica.
+type MemorySink struct {
+	mu     sync.Mutex
+	buf    bytes.Buffer
+	closed bool
+}
+
+// NewMemorySink crea un MemorySink vuoto.
+// Parametri: nessuno
+// Ritorna: *MemorySink
+func NewMemorySink() *MemorySink {
+	return &MemorySink{}
+}
+
+// Write accoda i byte al buffer in memoria.
+// Parametri: p byte da scrivere
+// Ritorna: numero di byte scritti ed ErrSinkClosed se il sink è chiuso
+func (ms *MemorySink) Write(p []byte) (int, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if ms.closed {
+		return 0, ErrSinkClosed
+	}
+	return ms.buf.Write(p)
+}
+
+// Flush non fa nulla: i dati sono già in memoria.
+// Parametri: nessuno
+// Ritorna: nil
+func (ms *MemorySink) Flush() error {
+	return nil
+}
+
+// Close impedisce nuove scritture; il contenuto resta leggibile.
+// Parametri: nessuno
+// Ritorna: nil
+func (ms *MemorySink) Close() error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.closed = true
+	return nil
+}
+
+// String restituisce una copia del contenuto ricevuto.
+// Parametri: nessuno
+// Ritorna: string
+func (ms *MemorySink) String() string {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	return ms.buf.String()
+}
+
+// Reset svuota il contenuto ricevuto.
+// Parametri: nessuno
+// Ritorna: nulla
+func (ms *MemorySink) Reset() {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.buf.Reset()
+}
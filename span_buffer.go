@@ -0,0 +1,180 @@
+package loggerhandler
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// SpanBufferPolicy stabilisce cosa fa uno span quando il suo buffer contiene già bufferSize record.
+type SpanBufferPolicy int
+
+const (
+	// SpanBufferFlushEarly invia i record accumulati come OpLog e svuota il buffer
+	// (comportamento predefinito: nessun record viene perso).
+	SpanBufferFlushEarly SpanBufferPolicy = iota
+	// SpanBufferRing mantiene gli ultimi bufferSize record scartando i più vecchi.
+	SpanBufferRing
+	// SpanBufferKeepFirst mantiene i primi bufferSize record scartando i successivi.
+	SpanBufferKeepFirst
+)
+
+// String restituisce il nome della policy.
+// Parametri: nessuno
+// Ritorna: string
+func (p SpanBufferPolicy) String() string {
+	switch p {
+	case SpanBufferFlushEarly:
+		return "FlushEarly"
+	case SpanBufferRing:
+		return "Ring"
+	case SpanBufferKeepFirst:
+		return "KeepFirst"
+	default:
+		return "Unknown"
+	}
+}
+
+// SetSpanBufferPolicy imposta la policy del buffer per gli span creati da questo momento.
+// Cosa fa: con SpanBufferRing e SpanBufferKeepFirst i record scartati sono conteggiati e
+//
+//	l'output dello span riporta un record "N records dropped" al loro posto.
+//
+// Parametri: policy policy da applicare
+// Ritorna: nulla
+func (lh *LoggerHandler) SetSpanBufferPolicy(policy SpanBufferPolicy) {
+	lh.mu.Lock()
+	defer lh.mu.Unlock()
+	lh.spanBufferPolicy = policy
+}
+
+// GetSpanBufferPolicy restituisce la policy del buffer applicata ai nuovi span.
+// Parametri: nessuno
+// Ritorna: SpanBufferPolicy
+func (lh *LoggerHandler) GetSpanBufferPolicy() SpanBufferPolicy {
+	lh.mu.Lock()
+	defer lh.mu.Unlock()
+	return lh.spanBufferPolicy
+}
+
+// GetDroppedRecordsCounter restituisce il contatore dei record scartati dal buffer degli span.
+// Parametri: nessuno
+// Ritorna: Int64CounterLike (può essere nil)
+func (lh *LoggerHandler) GetDroppedRecordsCounter() Int64CounterLike {
+	return lh.droppedRecordsCounter
+}
+
+// GetBufferPolicy restituisce la policy applicata quando il buffer dello span è pieno.
+// Parametri: nessuno
+// Ritorna: SpanBufferPolicy
+func (sl *SpanLogger) GetBufferPolicy() SpanBufferPolicy {
+	return sl.bufferPolicy
+}
+
+// effectiveBufferPolicy restituisce la policy effettivamente applicata al buffer pieno.
+// Cosa fa: in modalità flight recorder SpanBufferFlushEarly equivale a SpanBufferRing,
+//
+//	perché un invio anticipato scriverebbe i record che la modalità deve trattenere.
+//
+// Parametri: nessuno
+// Ritorna: SpanBufferPolicy
+func (sl *SpanLogger) effectiveBufferPolicy() SpanBufferPolicy {
+	if sl.bufferPolicy == SpanBufferFlushEarly && sl.isFlightRecorder() {
+		return SpanBufferRing
+	}
+	return sl.bufferPolicy
+}
+
+// bufferRecord inserisce un record nel buffer rispettando bufferSize e la policy dello span.
+// Cosa fa: con SpanBufferFlushEarly invia prima i record accumulati; con SpanBufferRing
+//
+//	sovrascrive il record più vecchio; con SpanBufferKeepFirst scarta il record, a meno che
+//	il record non provochi l'invio del buffer (vedi addRecord): in quel caso è conservato
+//	oltre bufferSize, così che il record che ha causato l'invio compaia sempre nell'output.
+//	Un bufferSize <= 0 lascia il buffer illimitato.
+//
+// Parametri: record record da inserire
+// Ritorna: nulla
+// Richiede che il chiamante abbia acquisito sl.mu.
+func (sl *SpanLogger) bufferRecord(record slog.Record) {
+	if sl.bufferSize <= 0 || len(sl.buffer) < sl.bufferSize {
+		sl.buffer = append(sl.buffer, record)
+		return
+	}
+	switch sl.effectiveBufferPolicy() {
+	case SpanBufferRing:
+		sl.buffer[sl.ringHead] = record
+		sl.ringHead = (sl.ringHead + 1) % len(sl.buffer)
+		sl.dropped++
+	case SpanBufferKeepFirst:
+		if !sl.isFlightRecorder() && record.Level >= sl.logLevel {
+			// addRecord invia subito il buffer: il record va conservato
+			sl.buffer = append(sl.buffer, record)
+			return
+		}
+		sl.dropped++
+	default:
+		sl.sendLogCmd(context.Background(), OpLog, nil)
+		sl.buffer = append(sl.buffer, record)
+	}
+}
+
+// appendTerminalRecord aggiunge il record che chiude lo span (errore, timeout, interruzione).
+// Cosa fa: il record è sempre conservato, anche a buffer pieno, e resta l'ultimo dell'output.
+// Parametri: record record da aggiungere
+// Ritorna: nulla
+// Richiede che il chiamante abbia acquisito sl.mu.
+func (sl *SpanLogger) appendTerminalRecord(record slog.Record) {
+	sl.unwrapRing()
+	sl.buffer = append(sl.buffer, record)
+	sl.walAppendRecord(record)
+}
+
+// unwrapRing riporta in ordine cronologico un buffer usato come ring.
+// Parametri: nessuno
+// Ritorna: nulla
+// Richiede che il chiamante abbia acquisito sl.mu.
+func (sl *SpanLogger) unwrapRing() {
+	if sl.ringHead == 0 {
+		return
+	}
+	records := make([]slog.Record, 0, len(sl.buffer)+1)
+	records = append(records, sl.buffer[sl.ringHead:]...)
+	records = append(records, sl.buffer[:sl.ringHead]...)
+	sl.buffer = records
+	sl.ringHead = 0
+}
+
+// orderedRecords riporta il buffer in ordine cronologico e aggiunge l'eventuale marker dei record scartati.
+// Cosa fa: è usato da buildLogCmd; azzera la posizione del ring e il conteggio dei record scartati.
+// Parametri: nessuno
+// Ritorna: []slog.Record da inviare con il comando
+// Richiede che il chiamante abbia acquisito sl.mu.
+func (sl *SpanLogger) orderedRecords() []slog.Record {
+	sl.unwrapRing()
+	records := sl.buffer
+	if sl.dropped == 0 {
+		return records
+	}
+
+	marker := slog.NewRecord(time.Now(), slog.LevelWarn, fmt.Sprintf("%d records dropped", sl.dropped), 0)
+	policy := sl.effectiveBufferPolicy()
+	marker.AddAttrs(slog.Int("dropped", sl.dropped), slog.String("policy", policy.String()))
+	if lh := sl.loggerHandler; lh != nil && lh.meter != nil && lh.droppedRecordsCounter != nil {
+		lh.droppedRecordsCounter.Add(int64(sl.dropped))
+	}
+	sl.dropped = 0
+
+	out := make([]slog.Record, 0, len(records)+1)
+	if policy == SpanBufferRing {
+		// i record scartati sono i più vecchi: il marker li precede
+		out = append(out, marker)
+		return append(out, records...)
+	}
+	// i record scartati seguono i primi bufferSize (un record terminale può venire dopo)
+	at := min(sl.bufferSize, len(records))
+	out = append(out, records[:at]...)
+	out = append(out, marker)
+	return append(out, records[at:]...)
+}
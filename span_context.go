@@ -0,0 +1,34 @@
+package loggerhandler
+
+import "context"
+
+// spanContextKey è la chiave privata usata per salvare lo SpanLogger nel context.Context.
+type spanContextKey struct{}
+
+// ContextWithSpan restituisce un nuovo contesto che trasporta lo SpanLogger.
+// Cosa fa: permette di recuperare lo span in profondità nello stack di chiamate
+//
+//	senza passarne il puntatore a ogni funzione.
+//
+// Parametri:
+//   - ctx: contesto di partenza
+//   - span: SpanLogger da associare al contesto
+//
+// Ritorna: context.Context derivato da ctx
+func ContextWithSpan(ctx context.Context, span *SpanLogger) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return context.WithValue(ctx, spanContextKey{}, span)
+}
+
+// SpanFromContext restituisce lo SpanLogger salvato nel contesto.
+// Parametri: ctx contesto da interrogare
+// Ritorna: *SpanLogger (nil se il contesto non contiene uno span)
+func SpanFromContext(ctx context.Context) *SpanLogger {
+	if ctx == nil {
+		return nil
+	}
+	span, _ := ctx.Value(spanContextKey{}).(*SpanLogger)
+	return span
+}
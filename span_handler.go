@@ -0,0 +1,122 @@
+package loggerhandler
+
+import (
+	"context"
+	"log/slog"
+)
+
+// spanHandlerEntry rappresenta un elemento della catena di WithAttrs/WithGroup.
+// Campi:
+//   - group: nome del gruppo aperto (vuoto se l'elemento contiene attributi)
+//   - attrs: attributi aggiunti tramite WithAttrs
+type spanHandlerEntry struct {
+	group string
+	attrs []slog.Attr
+}
+
+// spanHandler è uno slog.Handler che accumula i record nel buffer di uno SpanLogger.
+// Cosa fa: permette di usare uno span con un *slog.Logger; i record passano da addRecord
+//
+//	e quindi seguono le stesse regole di livello e lo stesso percorso sendLogCmd.
+type spanHandler struct {
+	span    *SpanLogger
+	entries []spanHandlerEntry
+}
+
+// Handler restituisce uno slog.Handler che scrive nel buffer dello span.
+// Cosa fa: crea un handler da usare con slog.New, ad esempio slog.New(span.Handler()).
+// Nota: i record di livello Error passano dalle regole di livello ma non rilasciano lo span;
+//
+//	per chiudere lo span con errore va usato il metodo Error dello SpanLogger.
+//
+// Parametri: nessuno
+// Ritorna: slog.Handler legato allo span
+func (sl *SpanLogger) Handler() slog.Handler {
+	return &spanHandler{span: sl}
+}
+
+// Enabled indica se il livello è gestito dall'handler.
+// Cosa fa: ritorna sempre true perché anche i record sotto logLevel vengono
+//
+//	conservati nel buffer e scritti al rilascio dello span.
+//
+// Parametri:
+//   - ctx: contesto (non usato)
+//   - level: livello del record
+//
+// Ritorna: bool
+func (h *spanHandler) Enabled(_ context.Context, _ slog.Level) bool {
+	return true
+}
+
+// Handle aggiunge il record al buffer dello span.
+// Cosa fa: clona il record applicando attributi e gruppi dell'handler e lo passa ad addRecord.
+// Parametri:
+//   - ctx: contesto propagato fino all'handler di formattazione
+//   - record: record da accumulare
+//
+// Ritorna: errore (sempre nil)
+func (h *spanHandler) Handle(ctx context.Context, record slog.Record) error {
+	if len(h.entries) == 0 {
+		h.span.addRecord(ctx, record.Clone())
+		return nil
+	}
+
+	// Raccolgo gli attributi del record
+	attrs := make([]slog.Attr, 0, record.NumAttrs())
+	record.Attrs(func(a slog.Attr) bool {
+		attrs = append(attrs, a)
+		return true
+	})
+
+	// Applico la catena dall'elemento più interno a quello più esterno
+	for i := len(h.entries) - 1; i >= 0; i-- {
+		entry := h.entries[i]
+		if entry.group == "" {
+			attrs = append(append([]slog.Attr{}, entry.attrs...), attrs...)
+			continue
+		}
+		// Un gruppo vuoto viene omesso, come fanno gli handler di slog
+		if len(attrs) == 0 {
+			continue
+		}
+		attrs = []slog.Attr{{Key: entry.group, Value: slog.GroupValue(attrs...)}}
+	}
+
+	out := slog.NewRecord(record.Time, record.Level, record.Message, record.PC)
+	out.AddAttrs(attrs...)
+	h.span.addRecord(ctx, out)
+	return nil
+}
+
+// WithAttrs restituisce un nuovo handler con gli attributi aggiunti.
+// Parametri: attrs attributi da aggiungere a ogni record
+// Ritorna: slog.Handler
+func (h *spanHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if len(attrs) == 0 {
+		return h
+	}
+	return h.with(spanHandlerEntry{attrs: append([]slog.Attr{}, attrs...)})
+}
+
+// WithGroup restituisce un nuovo handler che qualifica gli attributi successivi con il gruppo.
+// Parametri: name nome del gruppo
+// Ritorna: slog.Handler
+func (h *spanHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return h.with(spanHandlerEntry{group: name})
+}
+
+// with crea una copia dell'handler con un elemento aggiuntivo nella catena.
+// Parametri: entry elemento da aggiungere
+// Ritorna: *spanHandler
+func (h *spanHandler) with(entry spanHandlerEntry) *spanHandler {
+	entries := make([]spanHandlerEntry, len(h.entries), len(h.entries)+1)
+	copy(entries, h.entries)
+	return &spanHandler{
+		span:    h.span,
+		entries: append(entries, entry),
+	}
+}
@@ -1,20 +1,43 @@
 package loggerhandler
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log/slog"
+	"sync"
 	"time"
 )
 
+// SpanLogger accumula i record di uno span e li invia al LoggerHandler.
+// È sicuro per l'uso concorrente: più goroutine possono scrivere sullo stesso span,
+// anche mentre scatta il timeout o Shutdown lo interrompe. I record e i comandi di
+// ogni chiamata restano contigui e i comandi arrivano allo shard nell'ordine di invio.
 type SpanLogger struct {
 	id            string
+	parentID      string
+	traceID       string
+	traceState    string
+	sampled       bool
+	otelSpan      TraceSpanLike
+	otelEnded     bool // lo span di tracing è già stato chiuso da un comando di rilascio
+	startTime     time.Time
 	timeDuration  time.Duration
 	tags          []string
 	bufferSize    int
+	bufferPolicy  SpanBufferPolicy // cosa fare quando il buffer contiene bufferSize record
 	buffer        []slog.Record
+	ringHead      int // posizione del record più vecchio quando il buffer è usato come ring
+	dropped       int // record scartati dall'ultimo comando inviato
 	loggerHandler *LoggerHandler
 	logLevel      slog.Level
+	mode          SpanMode   // quando scrivere i record (vedi SpanMode)
+	mu            sync.Mutex // protegge buffer, lastSend, walSeq e otelEnded
+	lastSend      SendResult // esito dell'ultimo comando inviato
+	rejected      bool       // span creato durante lo shutdown: i comandi sono rifiutati
+	walSeq        uint64     // numero progressivo dei comandi inviati (write-ahead log)
+	walRecords    int        // record registrati nel write-ahead log dall'ultimo comando
+	stack         []uintptr  // stack del punto di fallimento, inviato con il prossimo comando
 }
 
 // NewSpanLogger crea un nuovo SpanLogger.
@@ -23,7 +46,7 @@ type SpanLogger struct {
 //   - id: identificatore univoco dello span
 //   - duration: durata (timeout) dello span
 //   - tags: lista di tag associati
-//   - bufferSize: dimensione del buffer interno (non usata direttamente qui ma conservata)
+//   - bufferSize: numero massimo di record nel buffer prima di applicare la policy (<= 0 illimitato)
 //   - loggerHandler: riferimento al LoggerHandler che gestisce lo span
 //   - level: livello minimo di log che provoca l'invio immediato
 //
@@ -36,6 +59,8 @@ func NewSpanLogger(id string, duration time.Duration, tags []string, bufferSize
 		bufferSize:    bufferSize,
 		loggerHandler: loggerHandler,
 		logLevel:      level,
+		sampled:       true,
+		startTime:     time.Now(),
 	}
 }
 
@@ -46,6 +71,46 @@ func (sl *SpanLogger) GetID() string {
 	return sl.id
 }
 
+// GetParentID restituisce l'id dello span padre.
+// Parametri: nessuno
+// Ritorna: string (vuota se lo span non ha un padre)
+func (sl *SpanLogger) GetParentID() string {
+	return sl.parentID
+}
+
+// GetTraceID restituisce l'id della trace a cui appartiene lo span.
+// Parametri: nessuno
+// Ritorna: string
+func (sl *SpanLogger) GetTraceID() string {
+	return sl.traceID
+}
+
+// Child crea uno span figlio registrato sullo stesso LoggerHandler.
+// Cosa fa: il figlio eredita trace ID, tracestate, tag, livello di log e dimensione del buffer del padre
+//
+//	e riporta l'id del padre nell'header del proprio output.
+//	Se il padre viene rilasciato prima del figlio, il figlio è segnalato come orfano
+//	nel log del padre ma resta attivo fino al proprio rilascio o timeout.
+//
+// Parametri:
+//   - duration: durata del timeout dello span figlio (0 per nessun timeout)
+//   - tags: tag aggiuntivi, accodati a quelli ereditati dal padre
+//
+// Ritorna: puntatore al nuovo SpanLogger figlio
+func (sl *SpanLogger) Child(duration time.Duration, tags ...string) *SpanLogger {
+	childTags := make([]string, 0, len(sl.tags)+len(tags))
+	childTags = append(childTags, sl.tags...)
+	childTags = append(childTags, tags...)
+	lh := sl.loggerHandler
+	child := lh.newSpan(duration, childTags, sl.bufferSize, sl.logLevel)
+	child.parentID = sl.id
+	child.traceID = sl.traceID
+	child.traceState = sl.traceState
+	child.sampled = sl.sampled
+	child.mode = sl.mode
+	return lh.registerSpan(context.Background(), child)
+}
+
 // GetDuration restituisce la durata (timeout) dello span.
 // Parametri: nessuno
 // Ritorna: time.Duration
@@ -60,6 +125,13 @@ func (sl *SpanLogger) GetTags() []string {
 	return sl.tags
 }
 
+// GetStartTime restituisce l'istante di creazione dello span.
+// Parametri: nessuno
+// Ritorna: time.Time
+func (sl *SpanLogger) GetStartTime() time.Time {
+	return sl.startTime
+}
+
 // GetBufferSize restituisce la dimensione del buffer interno dichiarata.
 // Parametri: nessuno
 // Ritorna: int
@@ -82,44 +154,185 @@ func (sl *SpanLogger) GetLogLevel() slog.Level {
 }
 
 // sendLogCmd costruisce e invia un LogCommand al LoggerHandler.
-// Cosa fa: crea un LogCommand con i record correnti, lo invia ad AppendCommand e pulisce il buffer.
+// Cosa fa: crea un LogCommand con i record correnti, lo invia ad AppendCommand, ne salva
+//
+//	l'esito (vedi LastSendResult) e pulisce il buffer.
+//
 // Parametri:
+//   - ctx: contesto propagato fino all'handler di formattazione
 //   - op: tipo di operazione (OpLog, OpReleaseSuccess, OpReleaseFailure, OpTimeout)
 //   - err: errore opzionale (usato per OpReleaseFailure/OpTimeout)
 //
 // Ritorna: nulla
-func (sl *SpanLogger) sendLogCmd(op OpType, err error) {
-	lc := LogCommand{
-		Op:      op,
-		SpanID:  sl.id,
-		Records: sl.buffer,
-		Err:     err,
-	}
-	// aggiungo il comando alla coda del LoggerHandler
-	sl.loggerHandler.AppendCommand(lc)
+// Richiede che il chiamante abbia acquisito sl.mu.
+func (sl *SpanLogger) sendLogCmd(ctx context.Context, op OpType, err error) {
+	lc := sl.buildLogCmd(ctx, op, err)
+	// chiudo lo span di tracing al rilascio, anche se il comando verrà scartato o rifiutato
+	sl.endTraceSpan(lc)
+	sl.enqueue(lc)
 	// svuoto il buffer
 	sl.buffer = []slog.Record{}
 }
 
-// Debug aggiunge un record di debug al buffer e, se il livello lo richiede, invia il comando.
+// enqueue registra il comando nel write-ahead log e lo accoda al LoggerHandler.
+// Parametri: lc comando costruito dallo span
+// Ritorna: nulla
+// Richiede che il chiamante abbia acquisito sl.mu.
+func (sl *SpanLogger) enqueue(lc LogCommand) {
+	if sl.rejected {
+		// lo span non è registrato: il comando è rifiutato come dopo Close
+		sl.loggerHandler.rejectAfterClose(lc)
+		sl.lastSend = SendRejectedClosed
+		return
+	}
+	// registro il comando nel write-ahead log prima di accodarlo
+	sl.loggerHandler.walCommand(lc)
+	// aggiungo il comando alla coda del LoggerHandler
+	sl.lastSend = sl.loggerHandler.AppendCommand(lc)
+}
+
+// buildLogCmd costruisce il LogCommand con i record correnti dello span.
+// Parametri:
+//   - ctx: contesto propagato fino all'handler di formattazione
+//   - op: tipo di operazione
+//   - err: errore opzionale
+//
+// Ritorna: LogCommand
+// Richiede che il chiamante abbia acquisito sl.mu.
+func (sl *SpanLogger) buildLogCmd(ctx context.Context, op OpType, err error) LogCommand {
+	lc := sl.newLogCmd(ctx, op, err, sl.orderedRecords(), sl.walRecords)
+	lc.stack = sl.stack
+	sl.walRecords, sl.stack = 0, nil
+	return lc
+}
+
+// newLogCmd costruisce un LogCommand dello span con i record indicati.
+// Parametri:
+//   - ctx: contesto propagato fino all'handler di formattazione
+//   - op: tipo di operazione
+//   - err: errore opzionale
+//   - records: record trasportati dal comando
+//   - walRecords: record registrati nel write-ahead log consumati dal comando
+//
+// Ritorna: LogCommand
+// Richiede che il chiamante abbia acquisito sl.mu.
+func (sl *SpanLogger) newLogCmd(ctx context.Context, op OpType, err error, records []slog.Record, walRecords int) LogCommand {
+	var otelSpanID string
+	if sl.otelSpan != nil {
+		if sc := sl.otelSpan.SpanContext(); sc.IsValid() {
+			otelSpanID = sc.SpanID().String()
+		}
+	}
+	if !sl.rejected {
+		// gli span rifiutati non sono nel write-ahead log: i loro comandi restano con walSeq 0
+		sl.walSeq++
+	}
+	return LogCommand{
+		Op:         op,
+		SpanID:     sl.id,
+		ParentID:   sl.parentID,
+		TraceID:    sl.traceID,
+		OtelSpanID: otelSpanID,
+		W3CSpanID:  sl.w3cID(),
+		Records:    records,
+		Err:        err,
+		Ctx:        ctx,
+		Tags:       sl.tags,
+		StartTime:  sl.startTime,
+		Time:       time.Now(),
+		walSeq:     sl.walSeq,
+		walRecords: walRecords,
+	}
+}
+
+// appendRecord aggiunge un record al buffer dello span e lo registra nel write-ahead log.
+// Cosa fa: il buffer è limitato da bufferSize secondo la policy dello span (vedi bufferRecord).
+// Parametri: record record da aggiungere
+// Ritorna: nulla
+// Richiede che il chiamante abbia acquisito sl.mu.
+func (sl *SpanLogger) appendRecord(record slog.Record) {
+	// inserisco prima nel buffer: un eventuale invio anticipato non deve contare questo record
+	sl.bufferRecord(record)
+	sl.walAppendRecord(record)
+}
+
+// walAppendRecord registra un record dello span nel write-ahead log.
+// Parametri: record record aggiunto al buffer
+// Ritorna: nulla
+// Richiede che il chiamante abbia acquisito sl.mu.
+func (sl *SpanLogger) walAppendRecord(record slog.Record) {
+	if !sl.rejected {
+		sl.loggerHandler.walRecord(sl.id, record)
+		sl.walRecords++
+	}
+}
+
+// addRecord aggiunge un record al buffer e, se il livello lo richiede, invia il comando.
+// Cosa fa: è il punto comune usato dai metodi di livello e dallo slog.Handler dello span,
+//
+//	così che tutti i record seguano le stesse regole di livello. In modalità flight recorder
+//	solo i record da Warn in su possono essere inviati subito (vedi SpanMode).
+//
 // Parametri:
+//   - ctx: contesto associato al record
+//   - record: slog.Record da accumulare
+//
+// Ritorna: nulla
+func (sl *SpanLogger) addRecord(ctx context.Context, record slog.Record) {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	if sl.isFlightRecorder() {
+		// in modalità flight recorder restano nello span fino al rilascio i record sotto Warn
+		if record.Level >= sl.logLevel && record.Level >= slog.LevelWarn {
+			sl.sendRecord(ctx, record)
+			return
+		}
+		sl.appendRecord(record)
+		return
+	}
+
+	// Aggiungo il record al buffer
+	sl.appendRecord(record)
+	if record.Level >= sl.logLevel {
+		sl.sendLogCmd(ctx, OpLog, nil)
+	}
+}
+
+// log crea un record con il livello indicato e lo passa ad addRecord.
+// Parametri:
+//   - ctx: contesto associato al record
+//   - lvl: livello del record
 //   - msg: messaggio di log
 //   - attrs: attributi opzionali
 //
 // Ritorna: nulla
-func (sl *SpanLogger) Debug(msg string, attrs ...slog.Attr) {
-	lvl := slog.LevelDebug
-
+func (sl *SpanLogger) log(ctx context.Context, lvl slog.Level, msg string, attrs ...slog.Attr) {
 	// Creo il record
 	record := slog.NewRecord(time.Now(), lvl, msg, 0)
 	record.AddAttrs(attrs...)
 
-	// Aggiungo il record al buffer
-	sl.buffer = append(sl.buffer, record)
+	sl.addRecord(ctx, record)
+}
 
-	if lvl >= sl.logLevel {
-		sl.sendLogCmd(OpLog, nil)
-	}
+// Debug aggiunge un record di debug al buffer e, se il livello lo richiede, invia il comando.
+// Parametri:
+//   - msg: messaggio di log
+//   - attrs: attributi opzionali
+//
+// Ritorna: nulla
+func (sl *SpanLogger) Debug(msg string, attrs ...slog.Attr) {
+	sl.log(context.Background(), slog.LevelDebug, msg, attrs...)
+}
+
+// DebugContext è come Debug ma propaga il contesto fino all'handler di formattazione.
+// Parametri:
+//   - ctx: contesto della chiamata
+//   - msg: messaggio di log
+//   - attrs: attributi opzionali
+//
+// Ritorna: nulla
+func (sl *SpanLogger) DebugContext(ctx context.Context, msg string, attrs ...slog.Attr) {
+	sl.log(ctx, slog.LevelDebug, msg, attrs...)
 }
 
 // Info aggiunge un record di info al buffer e, se il livello lo richiede, invia il comando.
@@ -129,18 +342,18 @@ func (sl *SpanLogger) Debug(msg string, attrs ...slog.Attr) {
 //
 // Ritorna: nulla
 func (sl *SpanLogger) Info(msg string, attrs ...slog.Attr) {
-	lvl := slog.LevelInfo
-
-	// Creo il record
-	record := slog.NewRecord(time.Now(), lvl, msg, 0)
-	record.AddAttrs(attrs...)
-
-	// Aggiungo il record al buffer
-	sl.buffer = append(sl.buffer, record)
+	sl.log(context.Background(), slog.LevelInfo, msg, attrs...)
+}
 
-	if lvl >= sl.logLevel {
-		sl.sendLogCmd(OpLog, nil)
-	}
+// InfoContext è come Info ma propaga il contesto fino all'handler di formattazione.
+// Parametri:
+//   - ctx: contesto della chiamata
+//   - msg: messaggio di log
+//   - attrs: attributi opzionali
+//
+// Ritorna: nulla
+func (sl *SpanLogger) InfoContext(ctx context.Context, msg string, attrs ...slog.Attr) {
+	sl.log(ctx, slog.LevelInfo, msg, attrs...)
 }
 
 // Warn aggiunge un record di warning al buffer e, se il livello lo richiede, invia il comando.
@@ -150,55 +363,160 @@ func (sl *SpanLogger) Info(msg string, attrs ...slog.Attr) {
 //
 // Ritorna: nulla
 func (sl *SpanLogger) Warn(msg string, attrs ...slog.Attr) {
-	lvl := slog.LevelWarn
+	sl.log(context.Background(), slog.LevelWarn, msg, attrs...)
+}
 
-	// Creo il record
-	record := slog.NewRecord(time.Now(), lvl, msg, 0)
-	record.AddAttrs(attrs...)
+// WarnContext è come Warn ma propaga il contesto fino all'handler di formattazione.
+// Parametri:
+//   - ctx: contesto della chiamata
+//   - msg: messaggio di log
+//   - attrs: attributi opzionali
+//
+// Ritorna: nulla
+func (sl *SpanLogger) WarnContext(ctx context.Context, msg string, attrs ...slog.Attr) {
+	sl.log(ctx, slog.LevelWarn, msg, attrs...)
+}
 
-	// Aggiungo il record al buffer
-	sl.buffer = append(sl.buffer, record)
+// ErrSpanFailed è l'errore usato da ReleaseFailure quando non viene fornito un errore.
+var ErrSpanFailed = errors.New("span fallito")
 
-	if lvl >= sl.logLevel {
-		sl.sendLogCmd(OpLog, nil)
-	}
+// LogError aggiunge un record di errore al buffer senza rilasciare lo span.
+// Cosa fa: è il metodo da usare per gli errori gestiti (es. un retry andato a buon fine);
+//
+//	il record segue le stesse regole di livello di Info e Warn e lo span resta aperto.
+//
+// Parametri:
+//   - msg: messaggio di errore
+//   - attrs: attributi opzionali
+//
+// Ritorna: nulla
+func (sl *SpanLogger) LogError(msg string, attrs ...slog.Attr) {
+	sl.log(context.Background(), slog.LevelError, msg, attrs...)
+}
+
+// LogErrorContext è come LogError ma propaga il contesto fino all'handler di formattazione.
+// Parametri:
+//   - ctx: contesto della chiamata
+//   - msg: messaggio di errore
+//   - attrs: attributi opzionali
+//
+// Ritorna: nulla
+func (sl *SpanLogger) LogErrorContext(ctx context.Context, msg string, attrs ...slog.Attr) {
+	sl.log(ctx, slog.LevelError, msg, attrs...)
 }
 
 // Error aggiunge un record di errore al buffer e invia immediatamente un OpReleaseFailure.
+// Cosa fa: rilascia lo span; per registrare un errore senza chiudere lo span usare LogError,
+//
+//	per rilasciarlo con un errore vero e proprio usare ReleaseFailure.
+//
 // Parametri:
 //   - msg: messaggio di errore
-//   - attrs: attributi opzionali (non usati qui ma accettati per compatibilità)
+//   - attrs: attributi opzionali
 //
 // Ritorna: nulla
 func (sl *SpanLogger) Error(msg string, attrs ...slog.Attr) {
-	lvl := slog.LevelError
+	sl.ErrorContext(context.Background(), msg, attrs...)
+}
+
+// ErrorContext è come Error ma propaga il contesto fino all'handler di formattazione.
+// Parametri:
+//   - ctx: contesto della chiamata
+//   - msg: messaggio di errore
+//   - attrs: attributi opzionali
+//
+// Ritorna: nulla
+func (sl *SpanLogger) ErrorContext(ctx context.Context, msg string, attrs ...slog.Attr) {
+	sl.releaseFailure(ctx, msg, fmt.Errorf("%s", msg), attrs...)
+}
+
+// ReleaseFailure rilascia lo span con un errore (OpReleaseFailure).
+// Cosa fa: aggiunge un record di errore con il messaggio di err e invia il comando
+//
+//	portando err così com'è, in modo che errors.Is e errors.As funzionino su LogCommand.Err.
+//
+// Parametri:
+//   - err: causa del fallimento (se nil viene usato ErrSpanFailed)
+//   - attrs: attributi opzionali del record di errore
+//
+// Ritorna: nulla
+func (sl *SpanLogger) ReleaseFailure(err error, attrs ...slog.Attr) {
+	sl.ReleaseFailureContext(context.Background(), err, attrs...)
+}
+
+// ReleaseFailureContext è come ReleaseFailure ma propaga il contesto fino all'handler di formattazione.
+// Parametri:
+//   - ctx: contesto della chiamata
+//   - err: causa del fallimento (se nil viene usato ErrSpanFailed)
+//   - attrs: attributi opzionali del record di errore
+//
+// Ritorna: nulla
+func (sl *SpanLogger) ReleaseFailureContext(ctx context.Context, err error, attrs ...slog.Attr) {
+	if err == nil {
+		err = ErrSpanFailed
+	}
+	sl.releaseFailure(ctx, err.Error(), err, attrs...)
+}
+
+// releaseFailure aggiunge il record di errore e invia OpReleaseFailure.
+// Parametri:
+//   - ctx: contesto della chiamata
+//   - msg: messaggio del record di errore
+//   - err: errore trasportato dal comando
+//   - attrs: attributi opzionali del record di errore
+//
+// Ritorna: nulla
+func (sl *SpanLogger) releaseFailure(ctx context.Context, msg string, err error, attrs ...slog.Attr) {
+	// Catturo lo stack prima di tutto, così parte dal chiamante
+	stack := sl.loggerHandler.captureStack()
 
 	// Creo il record
-	record := slog.NewRecord(time.Now(), lvl, msg, 0)
+	record := slog.NewRecord(time.Now(), slog.LevelError, msg, 0)
 	record.AddAttrs(attrs...)
 
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
 	// Aggiungo il record al buffer
-	sl.buffer = append(sl.buffer, record)
+	sl.appendTerminalRecord(record)
 
-	sl.sendLogCmd(OpReleaseFailure, fmt.Errorf("%s", msg))
+	sl.stack = stack
+	sl.sendLogCmd(ctx, OpReleaseFailure, err)
 }
 
 // ReleaseSuccess invia un comando di rilascio con successo (OpReleaseSuccess).
+// Cosa fa: in modalità flight recorder scarta prima i record di debug e info (vedi SpanMode).
 // Parametri: nessuno
 // Ritorna: nulla
 func (sl *SpanLogger) ReleaseSuccess() {
-	sl.sendLogCmd(OpReleaseSuccess, nil)
+	sl.ReleaseSuccessContext(context.Background())
+}
+
+// ReleaseSuccessContext è come ReleaseSuccess ma propaga il contesto fino all'handler di formattazione.
+// Parametri: ctx contesto della chiamata
+// Ritorna: nulla
+func (sl *SpanLogger) ReleaseSuccessContext(ctx context.Context) {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	if sl.isFlightRecorder() {
+		sl.discardVerboseRecords()
+	}
+	sl.sendLogCmd(ctx, OpReleaseSuccess, nil)
 }
 
 // Timeout genera un record di timeout, lo aggiunge al buffer e invia OpTimeout.
 // Cosa fa: crea un record di errore relativo al timeout e invia il comando di timeout.
+//
+//	Viene chiamato dallo scheduler dei timeout, anche mentre altre goroutine scrivono sullo span.
+//
 // Parametri: nessuno
 // Ritorna: nulla
 func (sl *SpanLogger) Timeout() {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
 	// Creo un record di timeout
 	record := slog.NewRecord(time.Now(), slog.LevelError, "Span timeout reached", 0)
 	// Aggiungo il record al buffer
-	sl.buffer = append(sl.buffer, record)
+	sl.appendTerminalRecord(record)
 	// Invio il comando di timeout
-	sl.sendLogCmd(OpTimeout, errors.New("Span timeout reached"))
+	sl.sendLogCmd(context.Background(), OpTimeout, errors.New("Span timeout reached"))
 }
@@ -0,0 +1,142 @@
+package loggerhandler
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// SpanMode stabilisce quando uno span scrive i propri record.
+type SpanMode int
+
+const (
+	// SpanModeImmediate invia subito i record pari o superiori a logLevel e gli altri
+	// al rilascio dello span (comportamento predefinito).
+	SpanModeImmediate SpanMode = iota
+	// SpanModeFlightRecorder conserva i record fino al rilascio: in caso di errore,
+	// timeout o interruzione vengono scritti tutti, con ReleaseSuccess i record di debug
+	// e info vengono scartati e restano solo quelli da Warn in su. I record da Warn in su
+	// pari o superiori a logLevel sono comunque inviati subito, come in SpanModeImmediate.
+	SpanModeFlightRecorder
+	// SpanModeFlightRecorderSummary è come SpanModeFlightRecorder ma con ReleaseSuccess
+	// sostituisce i record scartati con una riga di riepilogo.
+	SpanModeFlightRecorderSummary
+)
+
+// String restituisce il nome della modalità.
+// Parametri: nessuno
+// Ritorna: string
+func (m SpanMode) String() string {
+	switch m {
+	case SpanModeImmediate:
+		return "Immediate"
+	case SpanModeFlightRecorder:
+		return "FlightRecorder"
+	case SpanModeFlightRecorderSummary:
+		return "FlightRecorderSummary"
+	default:
+		return "Unknown"
+	}
+}
+
+// SetSpanMode imposta la modalità degli span creati da questo momento.
+// Cosa fa: gli span figli ereditano la modalità del padre.
+// Parametri: mode modalità da applicare
+// Ritorna: nulla
+func (lh *LoggerHandler) SetSpanMode(mode SpanMode) {
+	lh.mu.Lock()
+	defer lh.mu.Unlock()
+	lh.spanMode = mode
+}
+
+// GetSpanMode restituisce la modalità applicata ai nuovi span.
+// Parametri: nessuno
+// Ritorna: SpanMode
+func (lh *LoggerHandler) GetSpanMode() SpanMode {
+	lh.mu.Lock()
+	defer lh.mu.Unlock()
+	return lh.spanMode
+}
+
+// GetFlightRecorderDiscardedCounter restituisce il contatore dei record scartati al rilascio
+// con successo degli span in modalità flight recorder.
+// Parametri: nessuno
+// Ritorna: Int64CounterLike (può essere nil)
+func (lh *LoggerHandler) GetFlightRecorderDiscardedCounter() Int64CounterLike {
+	return lh.flightDiscardedCounter
+}
+
+// GetMode restituisce la modalità dello span.
+// Parametri: nessuno
+// Ritorna: SpanMode
+func (sl *SpanLogger) GetMode() SpanMode {
+	return sl.mode
+}
+
+// isFlightRecorder indica se lo span rimanda la scrittura dei record al rilascio.
+// Parametri: nessuno
+// Ritorna: bool
+func (sl *SpanLogger) isFlightRecorder() bool {
+	return sl.mode == SpanModeFlightRecorder || sl.mode == SpanModeFlightRecorderSummary
+}
+
+// sendRecord invia subito un solo record di uno span in modalità flight recorder.
+// Cosa fa: il record non entra nel buffer, così i record trattenuti restano in attesa del
+//
+//	rilascio; nel write-ahead log il comando consuma l'ultimo record registrato.
+//
+// Parametri:
+//   - ctx: contesto associato al record
+//   - record: record da inviare
+//
+// Ritorna: nulla
+// Richiede che il chiamante abbia acquisito sl.mu.
+func (sl *SpanLogger) sendRecord(ctx context.Context, record slog.Record) {
+	if !sl.rejected {
+		sl.loggerHandler.walRecord(sl.id, record)
+	}
+	lc := sl.newLogCmd(ctx, OpLog, nil, []slog.Record{record}, 1)
+	lc.walTail = true
+	sl.enqueue(lc)
+}
+
+// discardVerboseRecords toglie dal buffer i record di debug e info prima di un ReleaseSuccess.
+// Cosa fa: in SpanModeFlightRecorderSummary li sostituisce con una riga di riepilogo
+//
+//	che riporta quanti record di ciascun livello sono stati scartati.
+//
+// Parametri: nessuno
+// Ritorna: nulla
+// Richiede che il chiamante abbia acquisito sl.mu.
+func (sl *SpanLogger) discardVerboseRecords() {
+	sl.unwrapRing()
+	kept := sl.buffer[:0]
+	var debug, info int
+	for _, r := range sl.buffer {
+		switch {
+		case r.Level < slog.LevelInfo:
+			debug++
+		case r.Level < slog.LevelWarn:
+			info++
+		default:
+			kept = append(kept, r)
+		}
+	}
+	discarded := debug + info
+	if discarded == 0 {
+		return
+	}
+	// azzero la coda per non trattenere i record scartati
+	clear(sl.buffer[len(kept):])
+	sl.buffer = kept
+
+	if sl.mode == SpanModeFlightRecorderSummary {
+		summary := slog.NewRecord(time.Now(), slog.LevelInfo, fmt.Sprintf("%d debug/info records discarded", discarded), 0)
+		summary.AddAttrs(slog.Int("debug", debug), slog.Int("info", info))
+		sl.buffer = append(sl.buffer, summary)
+	}
+	if lh := sl.loggerHandler; lh != nil && lh.meter != nil && lh.flightDiscardedCounter != nil {
+		lh.flightDiscardedCounter.Add(int64(discarded))
+	}
+}
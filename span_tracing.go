@@ -0,0 +1,175 @@
+package loggerhandler
+
+import (
+	"context"
+	"crypto/rand"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// SetTracer abilita il collegamento tra SpanLogger e span di tracing OpenTelemetry.
+// Cosa fa: da questo momento ogni nuovo span avvia anche uno span di tracing; il rilascio
+//
+//	dello SpanLogger ne imposta lo stato e lo chiude. Passare nil disabilita il collegamento.
+//	Un trace ID fornito dal chiamante diventa il trace ID dello span di tracing; gli span
+//	senza trace ID adottano quello dello span OpenTelemetry.
+//
+// Parametri: tracer implementazione di TracerInterface (può essere nil)
+// Ritorna: nulla
+func (lh *LoggerHandler) SetTracer(tracer TracerInterface) {
+	lh.mu.Lock()
+	defer lh.mu.Unlock()
+	lh.tracer = tracer
+}
+
+// GetTracer restituisce il TracerInterface configurato.
+// Parametri: nessuno
+// Ritorna: TracerInterface (può essere nil)
+func (lh *LoggerHandler) GetTracer() TracerInterface {
+	lh.mu.Lock()
+	defer lh.mu.Unlock()
+	return lh.tracer
+}
+
+// startTraceSpan avvia lo span di tracing associato a uno SpanLogger appena creato.
+// Cosa fa: se è configurato un tracer avvia lo span come figlio dello span di tracing
+//
+//	del padre locale, di quello presente nel contesto o del padre remoto ricevuto via traceparent.
+//	In assenza di un padre un trace ID fornito dal chiamante è usato come padre remoto, così
+//	che lo span di tracing appartenga alla stessa trace; solo un trace ID generato viene
+//	sostituito con quello dello span di tracing.
+//
+// Parametri:
+//   - ctx: contesto di partenza per lo span di tracing
+//   - span: SpanLogger non ancora registrato
+//   - generated: true se il trace ID dello span è stato generato da registerSpan
+//
+// Ritorna: nulla
+func (lh *LoggerHandler) startTraceSpan(ctx context.Context, span *SpanLogger, generated bool) {
+	lh.mu.Lock()
+	tracer := lh.tracer
+	var parent *SpanLogger
+	if span.parentID != "" {
+		parent = lh.spans[span.parentID]
+	}
+	lh.mu.Unlock()
+
+	if tracer == nil {
+		return
+	}
+
+	if parent != nil && parent.otelSpan != nil {
+		// Padre locale con tracing: lo span di tracing diventa suo figlio
+		ctx = trace.ContextWithSpanContext(ctx, parent.otelSpan.SpanContext())
+	} else if !trace.SpanContextFromContext(ctx).IsValid() {
+		// Padre remoto ricevuto tramite traceparent (se il contesto non ne trasporta già uno)
+		if remote, ok := remoteSpanContext(span); ok {
+			ctx = trace.ContextWithRemoteSpanContext(ctx, remote)
+		} else if remote, ok := suppliedSpanContext(span); ok && !generated {
+			// Trace ID fornito dal chiamante senza uno span padre
+			ctx = trace.ContextWithRemoteSpanContext(ctx, remote)
+		}
+	}
+
+	name := "span"
+	if len(span.tags) > 0 {
+		name = span.tags[0]
+	}
+	_, otelSpan := tracer.Start(ctx, name, trace.WithAttributes(
+		attribute.String("logger.span_id", span.id),
+		attribute.StringSlice("logger.tags", span.tags),
+	))
+	if otelSpan == nil {
+		return
+	}
+	span.otelSpan = otelSpan
+	if sc := otelSpan.SpanContext(); sc.IsValid() && generated {
+		span.traceID = sc.TraceID().String()
+	}
+}
+
+// remoteSpanContext ricostruisce lo SpanContext del padre remoto di uno span.
+// Parametri: span SpanLogger con trace ID e parent ID in formato W3C
+// Ritorna: trace.SpanContext remoto e flag che indica se è valido
+func remoteSpanContext(span *SpanLogger) (trace.SpanContext, bool) {
+	traceID, err := trace.TraceIDFromHex(span.traceID)
+	if err != nil {
+		return trace.SpanContext{}, false
+	}
+	spanID, err := trace.SpanIDFromHex(span.parentID)
+	if err != nil {
+		return trace.SpanContext{}, false
+	}
+	return newRemoteSpanContext(span, traceID, spanID)
+}
+
+// suppliedSpanContext costruisce lo SpanContext remoto che porta il trace ID fornito per uno span.
+// Cosa fa: lo span ID del padre remoto è casuale, dato che il chiamante fornisce solo la trace.
+// Parametri: span SpanLogger con trace ID in formato W3C
+// Ritorna: trace.SpanContext remoto e flag che indica se è valido
+func suppliedSpanContext(span *SpanLogger) (trace.SpanContext, bool) {
+	traceID, err := trace.TraceIDFromHex(span.traceID)
+	if err != nil {
+		return trace.SpanContext{}, false
+	}
+	var spanID trace.SpanID
+	for !spanID.IsValid() {
+		_, _ = rand.Read(spanID[:])
+	}
+	return newRemoteSpanContext(span, traceID, spanID)
+}
+
+// newRemoteSpanContext costruisce uno SpanContext remoto con flag e tracestate dello span.
+// Parametri:
+//   - span: SpanLogger da cui leggere campionamento e tracestate
+//   - traceID: trace ID del padre remoto
+//   - spanID: span ID del padre remoto
+//
+// Ritorna: trace.SpanContext remoto e flag che indica se è valido
+func newRemoteSpanContext(span *SpanLogger, traceID trace.TraceID, spanID trace.SpanID) (trace.SpanContext, bool) {
+	var flags trace.TraceFlags
+	if span.sampled {
+		flags = trace.FlagsSampled
+	}
+	state, _ := trace.ParseTraceState(span.traceState)
+	sc := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     spanID,
+		TraceFlags: flags,
+		TraceState: state,
+		Remote:     true,
+	})
+	return sc, sc.IsValid()
+}
+
+// endTraceSpan imposta lo stato e chiude lo span di tracing dello SpanLogger al rilascio.
+// Cosa fa: è chiamato da chi invia il comando, così lo span di tracing viene chiuso anche
+//
+//	se il comando è poi scartato o rifiutato. OpReleaseSuccess imposta lo stato Ok; gli
+//	altri rilasci registrano l'errore e impostano lo stato Error. I comandi OpLog e i
+//	rilasci successivi al primo sono ignorati.
+//
+// Parametri: cmd LogCommand di rilascio appena costruito
+// Ritorna: nulla
+// Richiede che il chiamante abbia acquisito sl.mu.
+func (sl *SpanLogger) endTraceSpan(cmd LogCommand) {
+	if cmd.Op == OpLog || sl.otelSpan == nil || sl.otelEnded {
+		return
+	}
+	sl.otelEnded = true
+
+	switch cmd.Op {
+	case OpReleaseSuccess:
+		sl.otelSpan.SetStatus(codes.Ok, "")
+	default:
+		description := cmd.TypeString()
+		if cmd.Err != nil {
+			sl.otelSpan.RecordError(cmd.Err)
+			description = cmd.Err.Error()
+		}
+		sl.otelSpan.SetStatus(codes.Error, description)
+	}
+	sl.otelSpan.End()
+}
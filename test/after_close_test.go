@@ -0,0 +1,56 @@
+package loggerhandler_test
+
+import (
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+
+	loggerhandler "github.com/Mrpagio/logger-handler"
+)
+
+func TestLoggingAfterCloseIsRejected(t *testing.T) {
+	lh, meter := makeSinkTestHandler(t, 10, nil, nil)
+	fallback := loggerhandler.NewMemorySink()
+	lh.SetFallbackWriter(fallback)
+
+	span := lh.AddSpan(0, nil, 5, slog.LevelDebug)
+	lh.Close()
+	if !lh.IsClosed() {
+		t.Fatal("expected handler to report closed")
+	}
+
+	span.Info("late info")
+	if span.LastSendResult() != loggerhandler.SendRejectedClosed {
+		t.Fatalf("expected RejectedClosed, got %s", span.LastSendResult())
+	}
+	span.Error("late failure")
+	if got := meter.value("logger_rejected_after_close"); got != 2 {
+		t.Fatalf("expected 2 rejected commands, got %d", got)
+	}
+	out := fallback.String()
+	if !strings.Contains(out, `"msg":"late info"`) || !strings.Contains(out, "OpType: ReleaseFailure") {
+		t.Fatalf("expected rejected commands on the fallback writer, got %q", out)
+	}
+}
+
+func TestCloseWhileSpansAreStillLogging(t *testing.T) {
+	lh := makeQuietTestHandler(t, 4)
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			span := lh.AddSpan(0, nil, 5, slog.LevelDebug)
+			<-start
+			for j := 0; j < 100; j++ {
+				span.Info("busy")
+			}
+			span.ReleaseSuccess()
+		}()
+	}
+	close(start)
+	lh.Close()
+	wg.Wait()
+}
@@ -0,0 +1,229 @@
+package loggerhandler_test
+
+import (
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	loggerhandler "github.com/Mrpagio/logger-handler"
+)
+
+// stallSink blocca la prima scrittura finché il test non chiama release
+type stallSink struct {
+	*loggerhandler.MemorySink
+	entered chan struct{}
+	gate    chan struct{}
+	first   bool
+}
+
+func newStallSink() *stallSink {
+	return &stallSink{
+		MemorySink: loggerhandler.NewMemorySink(),
+		entered:    make(chan struct{}),
+		gate:       make(chan struct{}),
+		first:      true,
+	}
+}
+
+func (s *stallSink) Write(p []byte) (int, error) {
+	if s.first {
+		s.first = false
+		close(s.entered)
+		<-s.gate
+	}
+	return s.MemorySink.Write(p)
+}
+
+func (s *stallSink) release() { close(s.gate) }
+
+// helper: crea un handler con buffer da 1 e il worker fermo sulla prima scrittura,
+// con un comando già in coda: il canale è pieno
+func makeStalledHandler(t *testing.T, policy loggerhandler.BackpressurePolicy, timeout time.Duration) (*loggerhandler.LoggerHandler, *loggerhandler.SpanLogger, *stallSink, *recordingMeter) {
+	t.Helper()
+	sink := newStallSink()
+	lh, meter := makeSinkTestHandler(t, 1, []loggerhandler.Sink{sink}, nil)
+	lh.SetBackpressurePolicy(policy, timeout)
+	if got, _ := lh.GetBackpressurePolicy(); got != policy {
+		t.Fatalf("expected policy %s, got %s", policy, got)
+	}
+
+	span := lh.AddSpan(0, nil, 5, slog.LevelDebug)
+	span.Info("in flight")
+	<-sink.entered
+	span.Info("queued")
+	return lh, span, sink, meter
+}
+
+func TestBackpressureDropNewest(t *testing.T) {
+	lh, span, sink, meter := makeStalledHandler(t, loggerhandler.BackpressureDropNewest, 0)
+	span.Info("dropped")
+	sink.release()
+	lh.Close()
+
+	if strings.Contains(sink.String(), "dropped") || !strings.Contains(sink.String(), "queued") {
+		t.Fatalf("unexpected output: %q", sink.String())
+	}
+	if got := meter.value("logger_discarded_commands"); got != 1 {
+		t.Fatalf("expected 1 discarded command, got %d", got)
+	}
+	if got := meter.policyValue("logger_discarded_commands", loggerhandler.BackpressureDropNewest); got != 1 {
+		t.Fatalf("expected the discard to be labelled with its policy, got %d", got)
+	}
+}
+
+func TestBackpressureDropOldest(t *testing.T) {
+	lh, span, sink, meter := makeStalledHandler(t, loggerhandler.BackpressureDropOldest, 0)
+	span.Info("newest")
+	sink.release()
+	lh.Close()
+
+	if strings.Contains(sink.String(), "queued") || !strings.Contains(sink.String(), "newest") {
+		t.Fatalf("unexpected output: %q", sink.String())
+	}
+	if got := meter.value("logger_evicted_commands"); got != 1 {
+		t.Fatalf("expected 1 evicted command, got %d", got)
+	}
+}
+
+func TestBackpressureDropOldestKeepsTerminalCommands(t *testing.T) {
+	lh, span, sink, meter := makeStalledHandler(t, loggerhandler.BackpressureDropOldest, 0)
+	released := lh.AddSpan(0, nil, 5, slog.LevelError)
+	released.Info("buffered until release")
+	// il rilascio toglie dalla coda l'OpLog "queued"
+	released.ReleaseSuccess()
+	// il nuovo OpLog toglie dalla coda il rilascio, che deve essere spostato e non scartato
+	span.Info("newest")
+	sink.release()
+	lh.Close()
+
+	out := sink.String()
+	if !strings.Contains(out, "OpType: ReleaseSuccess ----- Span ID: "+released.GetID()) {
+		t.Fatalf("expected the evicted release to be written, got %q", out)
+	}
+	if _, open := lh.GetSpans()[released.GetID()]; open {
+		t.Fatal("expected the evicted release to be processed")
+	}
+	if got := meter.policyValue("logger_evicted_commands", loggerhandler.BackpressureDropOldest); got != 1 {
+		t.Fatalf("expected only the OpLog to be evicted, got %d", got)
+	}
+}
+
+func TestBackpressureBlockWithTimeout(t *testing.T) {
+	lh, span, sink, meter := makeStalledHandler(t, loggerhandler.BackpressureBlockWithTimeout, 20*time.Millisecond)
+	start := time.Now()
+	span.Info("timed out")
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Fatalf("expected send to wait for the timeout, waited %v", elapsed)
+	}
+	sink.release()
+	lh.Close()
+
+	if strings.Contains(sink.String(), "timed out") {
+		t.Fatalf("unexpected output: %q", sink.String())
+	}
+	if meter.value("logger_block_timeouts") != 1 || meter.value("logger_discarded_commands") != 1 || meter.value("logger_blocked_sends") != 1 {
+		t.Fatal("expected block timeout, discard and blocked send to be counted")
+	}
+	policy := loggerhandler.BackpressureBlockWithTimeout
+	if meter.policyValue("logger_block_timeouts", policy) != 1 || meter.policyValue("logger_discarded_commands", policy) != 1 || meter.policyValue("logger_blocked_sends", policy) != 1 {
+		t.Fatal("expected the metrics to be labelled with the BlockWithTimeout policy")
+	}
+}
+
+func TestBackpressureBlockWithTimeoutNormalizesNonPositiveTimeout(t *testing.T) {
+	lh, span, sink, meter := makeStalledHandler(t, loggerhandler.BackpressureBlockWithTimeout, 0)
+	if _, timeout := lh.GetBackpressurePolicy(); timeout <= 0 {
+		t.Fatalf("expected a positive default timeout, got %v", timeout)
+	}
+	done := make(chan struct{})
+	go func() {
+		span.Info("timed out")
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("BlockWithTimeout with a zero timeout must not block forever")
+	}
+	sink.release()
+	lh.Close()
+
+	if got := meter.policyValue("logger_block_timeouts", loggerhandler.BackpressureBlockWithTimeout); got != 1 {
+		t.Fatalf("expected 1 block timeout, got %d", got)
+	}
+}
+
+func TestBackpressureBlock(t *testing.T) {
+	lh, span, sink, meter := makeStalledHandler(t, loggerhandler.BackpressureBlock, 0)
+	done := make(chan struct{})
+	go func() {
+		span.Info("waited")
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("expected send to block while the buffer is full")
+	case <-time.After(20 * time.Millisecond):
+	}
+	sink.release()
+	<-done
+	lh.Close()
+
+	if !strings.Contains(sink.String(), "waited") {
+		t.Fatalf("expected blocked command to be written: %q", sink.String())
+	}
+	if meter.value("logger_blocked_sends") != 1 || meter.value("logger_discarded_commands") != 0 {
+		t.Fatal("expected one blocked send and no discards")
+	}
+}
+
+func TestBackpressureNeverDropTerminal(t *testing.T) {
+	lh, span, sink, meter := makeStalledHandler(t, loggerhandler.BackpressureNeverDropTerminal, 0)
+	span.Info("dropped log")
+	done := make(chan struct{})
+	go func() {
+		span.ReleaseSuccess()
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("expected terminal command to wait for space")
+	case <-time.After(20 * time.Millisecond):
+	}
+	sink.release()
+	<-done
+	lh.Close()
+
+	if strings.Contains(sink.String(), "dropped log") {
+		t.Fatalf("unexpected output: %q", sink.String())
+	}
+	if _, open := lh.GetSpans()[span.GetID()]; open {
+		t.Fatal("expected the release command to be processed")
+	}
+	if meter.value("logger_discarded_commands") != 1 || meter.value("logger_blocked_sends") != 1 {
+		t.Fatal("expected the log to be discarded and the release to wait")
+	}
+}
+
+func TestSendResultReported(t *testing.T) {
+	lh, span, sink, _ := makeStalledHandler(t, loggerhandler.BackpressureDropNewest, 0)
+	if span.LastSendResult() != loggerhandler.SendAccepted || span.LastSendError() != nil {
+		t.Fatalf("expected queued command to be accepted, got %s", span.LastSendResult())
+	}
+	span.Info("dropped")
+	if span.LastSendResult() != loggerhandler.SendDroppedFull || !errors.Is(span.LastSendError(), loggerhandler.ErrCommandDropped) {
+		t.Fatalf("expected dropped command, got %s", span.LastSendResult())
+	}
+	if got := lh.AppendCommand(loggerhandler.LogCommand{Op: loggerhandler.OpLog, SpanID: span.GetID()}); got != loggerhandler.SendDroppedFull {
+		t.Fatalf("expected AppendCommand to report DroppedFull, got %s", got)
+	}
+	sink.release()
+	lh.Close()
+
+	span.Info("after close")
+	if span.LastSendResult() != loggerhandler.SendRejectedClosed || !errors.Is(span.LastSendError(), loggerhandler.ErrHandlerClosed) {
+		t.Fatalf("expected rejection after Close, got %s", span.LastSendResult())
+	}
+}
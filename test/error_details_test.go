@@ -0,0 +1,71 @@
+package loggerhandler_test
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"testing"
+
+	loggerhandler "github.com/Mrpagio/logger-handler"
+)
+
+// notFoundError è un errore con tipo concreto da riportare nell'output
+type notFoundError struct{ key string }
+
+func (e *notFoundError) Error() string { return "missing " + e.key }
+
+// helper: rilascia uno span con l'errore dato e restituisce l'output degli errori
+func failSpanOutput(t *testing.T, formatter loggerhandler.Formatter, captureStack bool, err error) string {
+	t.Helper()
+	sink := loggerhandler.NewMemorySink()
+	lh, _ := makeSinkTestHandler(t, 10, []loggerhandler.Sink{sink}, []loggerhandler.Sink{sink})
+	if formatter != nil {
+		lh.GetErrorHandler().SetFormatter(formatter)
+	}
+	lh.SetCaptureStackTrace(captureStack)
+	if lh.IsCaptureStackTrace() != captureStack {
+		t.Fatalf("expected stack capture %v", captureStack)
+	}
+
+	span := lh.AddSpan(0, nil, 10, slog.LevelError)
+	span.ReleaseFailure(err)
+	lh.Close()
+	return sink.String()
+}
+
+func TestFailureRecordIncludesErrorChain(t *testing.T) {
+	err := fmt.Errorf("load user: %w", errors.Join(&notFoundError{key: "user:42"}, errors.New("cache down")))
+	out := failSpanOutput(t, nil, false, err)
+
+	want := `"error":{"type":"*fmt.wrapError","chain":[{"msg":"missing user:42\ncache down","type":"*errors.joinError","causes":[` +
+		`{"msg":"missing user:42","type":"*loggerhandler_test.notFoundError"},{"msg":"cache down","type":"*errors.errorString"}]}]}`
+	if !strings.Contains(out, want) {
+		t.Fatalf("expected structured error chain %s, got %q", want, out)
+	}
+	if strings.Contains(out, `"stack"`) {
+		t.Fatalf("stack must not be captured unless enabled: %q", out)
+	}
+}
+
+func TestFailureRecordChainWithTextFormatter(t *testing.T) {
+	err := fmt.Errorf("load user: %w", &notFoundError{key: "user:42"})
+	out := failSpanOutput(t, loggerhandler.NewTextFormatter(), false, err)
+
+	if !strings.Contains(out, `error.type=*fmt.wrapError error.chain="[*loggerhandler_test.notFoundError(\"missing user:42\")]"`) {
+		t.Fatalf("expected readable error chain, got %q", out)
+	}
+}
+
+func TestFailureRecordStackTrace(t *testing.T) {
+	out := failSpanOutput(t, nil, true, errors.New("boom"))
+
+	// il primo frame è il chiamante di ReleaseFailure, non il pacchetto
+	i := strings.Index(out, `"stack":["`)
+	if i < 0 || !strings.HasPrefix(out[i+len(`"stack":["`):], "github.com/Mrpagio/logger-handler/test") || !strings.Contains(out[i:], ".failSpanOutput ") {
+		t.Fatalf("expected stack starting at the failure site, got %q", out)
+	}
+	if strings.Contains(out, "(*SpanLogger)") {
+		t.Fatalf("stack must not include the package's own frames: %q", out)
+	}
+}
@@ -0,0 +1,199 @@
+package loggerhandler_test
+
+import (
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+
+	loggerhandler "github.com/Mrpagio/logger-handler"
+)
+
+// helper: scrive uno span con due record usando il Formatter indicato e restituisce l'output
+func formatSpanOutput(t *testing.T, formatter loggerhandler.Formatter) (string, *loggerhandler.SpanLogger) {
+	t.Helper()
+	lh, logPath, _ := makeFileTestHandler(t, 10)
+	lh.GetLogHandler().SetFormatter(formatter)
+
+	span := lh.AddSpan(0, nil, 5, slog.LevelError)
+	span.Info("first", slog.String("user", "mario rossi"))
+	span.Debug("second", slog.Group("req", slog.Int("status", 200)))
+	span.ReleaseSuccess()
+	lh.Close()
+	return readLogFile(t, logPath), span
+}
+
+func TestDefaultFormatterIsBanner(t *testing.T) {
+	wc := loggerhandler.NewLogConfigs(false, "", 1, 1, false)
+	if wc.GetFormatter() == nil {
+		t.Fatal("expected a default formatter")
+	}
+	wc.SetFormatter(nil)
+	if wc.GetFormatter() == nil {
+		t.Fatal("expected SetFormatter(nil) to restore the default formatter")
+	}
+
+	out, span := formatSpanOutput(t, loggerhandler.NewBannerFormatter())
+	if !strings.HasPrefix(out, "----- OpType: ReleaseSuccess ----- Span ID: "+span.GetID()) {
+		t.Fatalf("unexpected banner header: %q", out)
+	}
+	if !strings.HasSuffix(out, "--------------------\n") {
+		t.Fatalf("unexpected banner footer: %q", out)
+	}
+}
+
+func TestJSONLinesFormatter(t *testing.T) {
+	out, span := formatSpanOutput(t, loggerhandler.NewJSONLinesFormatter())
+	lines := strings.Split(strings.TrimSuffix(out, "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, got %d: %q", len(lines), out)
+	}
+	for _, line := range lines {
+		var obj map[string]any
+		if err := json.Unmarshal([]byte(line), &obj); err != nil {
+			t.Fatalf("invalid JSON line %q: %v", line, err)
+		}
+		if obj["span_id"] != span.GetID() || obj["op"] != "ReleaseSuccess" {
+			t.Fatalf("expected span_id and op fields, got %v", obj)
+		}
+	}
+}
+
+func TestLogfmtFormatter(t *testing.T) {
+	out, span := formatSpanOutput(t, loggerhandler.NewLogfmtFormatter())
+	if !strings.Contains(out, `msg=first op=ReleaseSuccess user="mario rossi"`) {
+		t.Fatalf("unexpected logfmt output: %q", out)
+	}
+	if !strings.Contains(out, "req.status=200") || !strings.Contains(out, "span_id="+span.GetID()) {
+		t.Fatalf("expected grouped attrs and span id in logfmt output: %q", out)
+	}
+}
+
+func TestTextFormatter(t *testing.T) {
+	out, span := formatSpanOutput(t, loggerhandler.NewTextFormatter())
+	lines := strings.Split(strings.TrimSuffix(out, "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, got %d: %q", len(lines), out)
+	}
+	if !strings.Contains(lines[0], " INFO [ReleaseSuccess "+span.GetID()+`] first user="mario rossi"`) {
+		t.Fatalf("unexpected text line: %q", lines[0])
+	}
+	if !strings.Contains(lines[1], " DEBUG [ReleaseSuccess "+span.GetID()+"] second req.status=200") {
+		t.Fatalf("unexpected text line: %q", lines[1])
+	}
+}
+
+func TestJSONDocumentFormatter(t *testing.T) {
+	lh, logPath, errPath := makeFileTestHandler(t, 10)
+	lh.GetLogHandler().SetFormatter(loggerhandler.NewJSONDocumentFormatter())
+	lh.GetErrorHandler().SetFormatter(loggerhandler.NewJSONDocumentFormatter())
+
+	ok := lh.AddSpan(0, []string{"api", "orders"}, 5, slog.LevelError)
+	ok.Info("first")
+	ok.Debug("second")
+	ok.ReleaseSuccess()
+
+	failed := lh.AddSpan(0, nil, 5, slog.LevelError)
+	failed.Error("payment refused")
+	lh.Close()
+
+	type document struct {
+		SpanID     string           `json:"span_id"`
+		Op         string           `json:"op"`
+		Tags       []string         `json:"tags"`
+		StartTime  string           `json:"start_time"`
+		EndTime    string           `json:"end_time"`
+		DurationMs *float64         `json:"duration_ms"`
+		Outcome    string           `json:"outcome"`
+		Error      *string          `json:"error"`
+		Records    []map[string]any `json:"records"`
+	}
+
+	// ogni comando è un unico documento su una riga
+	logOut := readLogFile(t, logPath)
+	if strings.Count(logOut, "\n") != 1 {
+		t.Fatalf("expected a single document line, got %q", logOut)
+	}
+	var doc document
+	if err := json.Unmarshal([]byte(logOut), &doc); err != nil {
+		t.Fatalf("invalid document %q: %v", logOut, err)
+	}
+	if doc.SpanID != ok.GetID() || doc.Op != "ReleaseSuccess" || doc.Outcome != "success" || doc.Error != nil {
+		t.Fatalf("unexpected document metadata: %+v", doc)
+	}
+	if len(doc.Tags) != 2 || doc.Tags[1] != "orders" || doc.StartTime == "" || doc.EndTime == "" || doc.DurationMs == nil {
+		t.Fatalf("unexpected document timing/tags: %+v", doc)
+	}
+	if len(doc.Records) != 2 || doc.Records[0]["msg"] != "first" || doc.Records[1]["msg"] != "second" {
+		t.Fatalf("unexpected document records: %+v", doc.Records)
+	}
+
+	var failedDoc document
+	if err := json.Unmarshal([]byte(readLogFile(t, errPath)), &failedDoc); err != nil {
+		t.Fatalf("invalid failure document: %v", err)
+	}
+	if failedDoc.SpanID != failed.GetID() || failedDoc.Outcome != "failure" || failedDoc.Error == nil || *failedDoc.Error != "payment refused" {
+		t.Fatalf("unexpected failure document: %+v", failedDoc)
+	}
+	if len(failedDoc.Tags) != 0 || len(failedDoc.Records) != 2 {
+		t.Fatalf("unexpected failure document records/tags: %+v", failedDoc)
+	}
+}
+
+func TestJSONDocumentFormatterEmitsReleaseAfterFlushedRecords(t *testing.T) {
+	lh, logPath, _ := makeFileTestHandler(t, 10)
+	lh.GetLogHandler().SetFormatter(loggerhandler.NewJSONDocumentFormatter())
+
+	// il record è al livello dello span: viene inviato subito e il rilascio non ha record
+	span := lh.AddSpan(0, nil, 5, slog.LevelInfo)
+	span.Info("sent immediately")
+	span.ReleaseSuccess()
+	lh.Close()
+
+	lines := strings.Split(strings.TrimSpace(readLogFile(t, logPath)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected a log document and a release document, got %q", lines)
+	}
+	var release struct {
+		SpanID  string `json:"span_id"`
+		Op      string `json:"op"`
+		Outcome string `json:"outcome"`
+		Records []any  `json:"records"`
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &release); err != nil {
+		t.Fatalf("invalid release document %q: %v", lines[1], err)
+	}
+	if release.SpanID != span.GetID() || release.Op != "ReleaseSuccess" || release.Outcome != "success" || release.Records == nil || len(release.Records) != 0 {
+		t.Fatalf("unexpected release document: %+v", release)
+	}
+}
+
+func TestSetFormatterWhileLogging(t *testing.T) {
+	sink := loggerhandler.NewMemorySink()
+	lh, _ := makeSinkTestHandler(t, 64, []loggerhandler.Sink{sink}, nil)
+	lh.SetBackpressurePolicy(loggerhandler.BackpressureBlock, 0)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		// il Formatter cambia mentre i worker formattano i comandi
+		for i := 0; i < 100; i++ {
+			if i%2 == 0 {
+				lh.GetLogHandler().SetFormatter(loggerhandler.NewJSONLinesFormatter())
+			} else {
+				lh.GetLogHandler().SetFormatter(nil)
+			}
+		}
+	}()
+	span := lh.AddSpan(0, nil, 5, slog.LevelDebug)
+	for i := 0; i < 100; i++ {
+		span.Info("record")
+	}
+	<-done
+	span.ReleaseSuccess()
+	lh.Close()
+
+	if got := strings.Count(sink.String(), `"msg":"record"`); got != 100 {
+		t.Fatalf("expected 100 records, got %d", got)
+	}
+}
@@ -3,6 +3,7 @@ package loggerhandler_test
 import (
 	"log/slog"
 	"math/rand"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -189,3 +190,50 @@ func TestTimeoutAfterReleaseQueued(t *testing.T) {
 		t.Fatalf("span %s still present after Release+Timeout processing", id)
 	}
 }
+
+// Verifica che il trace ID sia generato, ereditato dai figli e scritto in header e record
+func TestTraceIDGrouping(t *testing.T) {
+	lh, logPath, _ := makeFileTestHandler(t, 10)
+
+	root := lh.AddSpan(0, nil, 5, slog.LevelDebug)
+	traceID := root.GetTraceID()
+	if len(traceID) != 32 {
+		t.Fatalf("expected 32 hex chars trace id, got %q", traceID)
+	}
+	child := root.Child(0)
+	if child.GetTraceID() != traceID {
+		t.Fatalf("expected child to inherit trace id %s, got %s", traceID, child.GetTraceID())
+	}
+
+	// span creato in un'altra goroutine con trace ID fornito
+	var other *loggerhandler.SpanLogger
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		other = lh.AddSpanWithTraceID(traceID, 0, nil, 5, slog.LevelDebug)
+		other.Info("from worker")
+		other.ReleaseSuccess()
+	}()
+	wg.Wait()
+	if other.GetTraceID() != traceID {
+		t.Fatalf("expected supplied trace id %s, got %s", traceID, other.GetTraceID())
+	}
+	if lh.AddSpan(0, nil, 5, slog.LevelDebug).GetTraceID() == traceID {
+		t.Fatal("expected a new span to start a new trace")
+	}
+
+	child.Info("child record")
+	child.ReleaseSuccess()
+	root.Info("root record")
+	root.ReleaseSuccess()
+	lh.Close()
+
+	out := readLogFile(t, logPath)
+	if got := strings.Count(out, "Trace ID: "+traceID); got != 3 {
+		t.Fatalf("expected 3 span headers with trace id, got %d in %q", got, out)
+	}
+	if got := strings.Count(out, `"trace_id":"`+traceID+`"`); got != 3 {
+		t.Fatalf("expected 3 records with trace id, got %d in %q", got, out)
+	}
+}
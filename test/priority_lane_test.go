@@ -0,0 +1,95 @@
+package loggerhandler_test
+
+import (
+	"log/slog"
+	"strings"
+	"testing"
+
+	loggerhandler "github.com/Mrpagio/logger-handler"
+)
+
+func TestPriorityLaneSurvivesLogStorm(t *testing.T) {
+	sink := newStallSink()
+	lh, meter := makeSinkTestHandler(t, 4, []loggerhandler.Sink{sink}, []loggerhandler.Sink{sink})
+
+	noisy := lh.AddSpan(0, nil, 5, slog.LevelDebug)
+	noisy.Debug("in flight")
+	<-sink.entered
+
+	// la tempesta di debug riempie il canale normale
+	for i := 0; i < 20; i++ {
+		noisy.Debug("storm")
+	}
+	failed := lh.AddSpan(0, nil, 5, slog.LevelError)
+	failed.Info("context")
+	failed.Error("payment refused")
+	sink.release()
+	lh.Close()
+
+	out := sink.String()
+	failurePos := strings.Index(out, "OpType: ReleaseFailure ----- Span ID: "+failed.GetID())
+	if failurePos < 0 {
+		t.Fatalf("expected failure report despite the log storm, got %q", out)
+	}
+	if stormPos := strings.Index(out, `"msg":"storm"`); stormPos >= 0 && stormPos < failurePos {
+		t.Fatal("expected failure report to be written before the queued debug logs")
+	}
+	if meter.value("logger_discarded_commands") == 0 {
+		t.Fatal("expected some debug logs to be discarded")
+	}
+}
+
+func TestPriorityReleaseWaitsForQueuedLogs(t *testing.T) {
+	sink := newStallSink()
+	lh, meter := makeSinkTestHandler(t, 4, []loggerhandler.Sink{sink}, []loggerhandler.Sink{sink})
+
+	blocker := lh.AddSpan(0, nil, 5, slog.LevelDebug)
+	blocker.Info("in flight")
+	<-sink.entered
+
+	span := lh.AddSpan(0, nil, 5, slog.LevelDebug)
+	span.Info("queued before failure")
+	span.Error("failed")
+	sink.release()
+	lh.Close()
+
+	out := sink.String()
+	if strings.Contains(out, "SpanID non trovato") || meter.value("logger_invalid_spans") != 0 {
+		t.Fatalf("expected queued log not to be reported as invalid, got %q", out)
+	}
+	logPos := strings.Index(out, `"msg":"queued before failure"`)
+	failurePos := strings.Index(out, "OpType: ReleaseFailure ----- Span ID: "+span.GetID())
+	if logPos < 0 || failurePos < 0 || logPos > failurePos {
+		t.Fatalf("expected queued log to be written before the failure report, got %q", out)
+	}
+}
+
+func TestPriorityReleaseKeepsOrderForManySpans(t *testing.T) {
+	sink := newStallSink()
+	lh, meter := makeSinkTestHandler(t, 8192, []loggerhandler.Sink{sink}, []loggerhandler.Sink{sink})
+
+	blocker := lh.AddSpan(0, nil, 5, slog.LevelDebug)
+	blocker.Info("in flight")
+	<-sink.entered
+
+	// più span di quanti ne possa ricordare qualunque elenco limitato di span rilasciati
+	const spans = 1500
+	for i := 0; i < spans; i++ {
+		span := lh.AddSpan(0, nil, 5, slog.LevelDebug)
+		span.Info("queued before failure")
+		span.Error("failed")
+	}
+	sink.release()
+	lh.Close()
+
+	out := sink.String()
+	if strings.Contains(out, "SpanID non trovato") {
+		t.Fatal("expected no queued log to be reported as an unknown span")
+	}
+	if got := meter.value("logger_invalid_spans"); got != 0 {
+		t.Fatalf("expected no invalid spans, got %d", got)
+	}
+	if got := meter.value("logger_failure_spans"); got != spans {
+		t.Fatalf("expected %d failures, got %d", spans, got)
+	}
+}
@@ -0,0 +1,74 @@
+package loggerhandler_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+
+	loggerhandler "github.com/Mrpagio/logger-handler"
+)
+
+func TestShardedHandlerKeepsSpanOrderAndAtomicWrites(t *testing.T) {
+	sink := loggerhandler.NewMemorySink()
+	cfg := loggerhandler.NewLogConfigs(false, "", 1, 1, false)
+	cfg.AddSink(sink)
+	lh := loggerhandler.NewLoggerHandlerWithOptions(cfg, cfg, &fakeMeter{}, 1024, loggerhandler.HandlerOptions{Shards: 4})
+
+	const spans, records = 16, 50
+	var wg sync.WaitGroup
+	for i := 0; i < spans; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			span := lh.AddSpan(0, nil, 5, slog.LevelDebug)
+			for j := 0; j < records; j++ {
+				span.Info("step", slog.Int("seq", j))
+			}
+			span.ReleaseSuccess()
+		}()
+	}
+	wg.Wait()
+	lh.Close()
+
+	// ogni blocco header/footer contiene solo record dello span dell'header,
+	// e i record di ogni span sono in ordine
+	next := make(map[string]int)
+	current := ""
+	for _, line := range strings.Split(strings.TrimSuffix(sink.String(), "\n"), "\n") {
+		switch {
+		case strings.HasPrefix(line, "----- OpType:"):
+			if current != "" {
+				t.Fatalf("header inside another span block: %q", line)
+			}
+			fmt.Sscanf(line[strings.Index(line, "Span ID: ")+len("Span ID: "):], "%s", &current)
+		case line == "--------------------":
+			current = ""
+		default:
+			var rec struct {
+				SpanID string `json:"span_id"`
+				Seq    int    `json:"seq"`
+			}
+			if err := json.Unmarshal([]byte(line), &rec); err != nil {
+				t.Fatalf("invalid record line %q: %v", line, err)
+			}
+			if rec.SpanID != current {
+				t.Fatalf("record of span %s inside block of span %s", rec.SpanID, current)
+			}
+			if rec.Seq != next[rec.SpanID] {
+				t.Fatalf("span %s: expected seq %d, got %d", rec.SpanID, next[rec.SpanID], rec.Seq)
+			}
+			next[rec.SpanID]++
+		}
+	}
+	if len(next) != spans {
+		t.Fatalf("expected records from %d spans, got %d", spans, len(next))
+	}
+	for id, n := range next {
+		if n != records {
+			t.Fatalf("span %s: expected %d records, got %d", id, records, n)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package loggerhandler_test
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	loggerhandler "github.com/Mrpagio/logger-handler"
+)
+
+func TestShutdownWaitsForInFlightSpans(t *testing.T) {
+	logSink := loggerhandler.NewMemorySink()
+	lh, _ := makeSinkTestHandler(t, 10, []loggerhandler.Sink{logSink}, nil)
+
+	span := lh.AddSpan(0, nil, 5, slog.LevelError)
+	span.Info("finishing")
+	go func() {
+		time.Sleep(30 * time.Millisecond)
+		span.ReleaseSuccess()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := lh.Shutdown(ctx); err != nil {
+		t.Fatalf("expected clean shutdown, got %v", err)
+	}
+	if !strings.Contains(logSink.String(), "OpType: ReleaseSuccess") {
+		t.Fatalf("expected released span to be written, got %q", logSink.String())
+	}
+	if !lh.IsClosed() {
+		t.Fatal("expected handler to be closed after Shutdown")
+	}
+}
+
+func TestShutdownAbortsOpenSpansAtDeadline(t *testing.T) {
+	errSink := loggerhandler.NewMemorySink()
+	lh, _ := makeSinkTestHandler(t, 10, nil, []loggerhandler.Sink{errSink})
+
+	stuck := lh.AddSpan(0, []string{"stuck"}, 5, slog.LevelError)
+	stuck.Info("buffered before shutdown")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- lh.Shutdown(ctx) }()
+
+	// durante lo shutdown i nuovi span vengono rifiutati
+	time.Sleep(10 * time.Millisecond)
+	late := lh.AddSpan(0, nil, 5, slog.LevelDebug)
+	late.Info("too late")
+	if late.LastSendResult() != loggerhandler.SendRejectedClosed {
+		t.Fatalf("expected new span to be rejected during shutdown, got %s", late.LastSendResult())
+	}
+
+	err := <-done
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline error, got %v", err)
+	}
+	out := errSink.String()
+	if !strings.Contains(out, "OpType: Aborted ----- Span ID: "+stuck.GetID()) {
+		t.Fatalf("expected aborted span output, got %q", out)
+	}
+	if !strings.Contains(out, `"msg":"buffered before shutdown"`) || !strings.Contains(out, loggerhandler.ErrAbortedAtShutdown.Error()) {
+		t.Fatalf("expected buffered records and abort reason, got %q", out)
+	}
+	if strings.Contains(out, "too late") {
+		t.Fatalf("expected rejected span not to be written, got %q", out)
+	}
+}
+
+func TestShutdownWithStalledSinkIsBounded(t *testing.T) {
+	sink := newStallSink()
+	defer sink.release()
+	lh, _ := makeSinkTestHandler(t, 1, []loggerhandler.Sink{sink}, []loggerhandler.Sink{sink})
+	lh.SetCloseTimeout(50 * time.Millisecond)
+	fallback := loggerhandler.NewMemorySink()
+	lh.SetFallbackWriter(fallback)
+
+	// il worker resta fermo sul sink e il canale è pieno
+	blocker := lh.AddSpan(0, nil, 5, slog.LevelDebug)
+	blocker.Info("in flight")
+	<-sink.entered
+	blocker.Info("queued")
+	stuck := lh.AddSpan(0, nil, 5, slog.LevelError)
+	stuck.Info("buffered before shutdown")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := lh.Shutdown(ctx)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expected Shutdown to return close to its deadline, took %v", elapsed)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) || !errors.Is(err, loggerhandler.ErrCloseTimeout) {
+		t.Fatalf("expected deadline and close timeout errors, got %v", err)
+	}
+	if !errors.Is(lh.CloseError(), loggerhandler.ErrCloseTimeout) {
+		t.Fatalf("expected CloseError to report the timeout, got %v", lh.CloseError())
+	}
+	if out := fallback.String(); !strings.Contains(out, "OpType: Aborted ----- Span ID: "+stuck.GetID()) {
+		t.Fatalf("expected aborted span on the fallback writer, got %q", out)
+	}
+}
+
+func TestSpanRejectedAfterCloseIsNotRegistered(t *testing.T) {
+	lh, meter := makeSinkTestHandler(t, 10, nil, nil)
+	lh.Close()
+	if lh.CloseError() != nil {
+		t.Fatalf("expected clean close, got %v", lh.CloseError())
+	}
+
+	active := meter.value("logger_active_spans")
+	span := lh.AddSpan(0, nil, 5, slog.LevelDebug)
+	if _, registered := lh.GetSpans()[span.GetID()]; registered {
+		t.Fatal("expected rejected span not to be left in the span map")
+	}
+	if got := meter.value("logger_active_spans"); got != active {
+		t.Fatalf("expected active spans gauge unchanged, got %d want %d", got, active)
+	}
+}
@@ -0,0 +1,223 @@
+package loggerhandler_test
+
+import (
+	"errors"
+	"log/slog"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	loggerhandler "github.com/Mrpagio/logger-handler"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// countingSink conta le chiamate a Flush e Close
+type countingSink struct {
+	*loggerhandler.MemorySink
+	flushes int
+	closes  int
+}
+
+func (s *countingSink) Flush() error {
+	s.flushes++
+	return s.MemorySink.Flush()
+}
+
+func (s *countingSink) Close() error {
+	s.closes++
+	return s.MemorySink.Close()
+}
+
+func TestAddSinkReceivesOutput(t *testing.T) {
+	logCfg := loggerhandler.NewLogConfigs(false, "", 1, 1, false)
+	errCfg := loggerhandler.NewLogConfigs(false, "", 1, 1, false)
+	logSink := &countingSink{MemorySink: loggerhandler.NewMemorySink()}
+	errSink := loggerhandler.NewMemorySink()
+	logCfg.AddSink(logSink)
+	logCfg.AddSink(nil)
+	errCfg.AddSink(errSink)
+	if got := len(logCfg.GetSinks()); got != 1 {
+		t.Fatalf("expected 1 sink, got %d", got)
+	}
+
+	lh := loggerhandler.NewLoggerHandler(logCfg, errCfg, &fakeMeter{}, 10)
+	ok := lh.AddSpan(0, nil, 5, slog.LevelError)
+	ok.Info("to memory")
+	ok.ReleaseSuccess()
+	failed := lh.AddSpan(0, nil, 5, slog.LevelError)
+	failed.Error("broken")
+	lh.Close()
+
+	if !strings.Contains(logSink.String(), `"msg":"to memory"`) {
+		t.Fatalf("expected record in log sink, got %q", logSink.String())
+	}
+	if !strings.Contains(errSink.String(), `"msg":"broken"`) {
+		t.Fatalf("expected record in error sink, got %q", errSink.String())
+	}
+	if logSink.flushes != 1 || logSink.closes != 1 {
+		t.Fatalf("expected sink flushed and closed once, got %d/%d", logSink.flushes, logSink.closes)
+	}
+	if _, err := errSink.Write([]byte("late")); err != loggerhandler.ErrSinkClosed {
+		t.Fatalf("expected ErrSinkClosed after Close, got %v", err)
+	}
+}
+
+func TestSharedWriterConfigsClosedOnce(t *testing.T) {
+	cfg := loggerhandler.NewLogConfigs(true, "", 1, 1, false)
+	sink := &countingSink{MemorySink: loggerhandler.NewMemorySink()}
+	cfg.AddSink(sink)
+	if got := len(cfg.GetSinks()); got != 2 {
+		t.Fatalf("expected console and memory sinks, got %d", got)
+	}
+
+	lh := loggerhandler.NewLoggerHandler(cfg, cfg, &fakeMeter{}, 10)
+	lh.Close()
+	if sink.closes != 1 {
+		t.Fatalf("expected shared sink closed once, got %d", sink.closes)
+	}
+}
+
+func TestWriterSinkAdapter(t *testing.T) {
+	var sb strings.Builder
+	sink := loggerhandler.NewWriterSink(&sb)
+	if _, err := sink.Write([]byte("hello")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := sink.Flush(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if sb.String() != "hello" {
+		t.Fatalf("unexpected content %q", sb.String())
+	}
+}
+
+// recordingCounter somma i valori ricevuti, in totale e per attributo "policy"
+type recordingCounter struct {
+	value    atomic.Int64
+	mu       sync.Mutex
+	byPolicy map[string]int64
+}
+
+func (c *recordingCounter) Add(v int64, opts ...metric.AddOption) {
+	c.value.Add(v)
+	attrs := metric.NewAddConfig(opts).Attributes()
+	policy, ok := attrs.Value("policy")
+	if !ok {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.byPolicy == nil {
+		c.byPolicy = make(map[string]int64)
+	}
+	c.byPolicy[policy.AsString()] += v
+}
+
+// recordingMeter restituisce contatori consultabili per nome
+type recordingMeter struct {
+	mu       sync.Mutex
+	counters map[string]*recordingCounter
+}
+
+func (m *recordingMeter) counter(name string) *recordingCounter {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.counters == nil {
+		m.counters = make(map[string]*recordingCounter)
+	}
+	if m.counters[name] == nil {
+		m.counters[name] = &recordingCounter{}
+	}
+	return m.counters[name]
+}
+
+func (m *recordingMeter) Int64Counter(name string, _ ...metric.InstrumentOption) (loggerhandler.Int64CounterLike, error) {
+	return m.counter(name), nil
+}
+
+func (m *recordingMeter) Int64UpDownCounter(name string, _ ...metric.InstrumentOption) (loggerhandler.Int64UpDownCounterLike, error) {
+	return m.counter(name), nil
+}
+
+// value restituisce il valore corrente del contatore indicato
+func (m *recordingMeter) value(name string) int64 {
+	return m.counter(name).value.Load()
+}
+
+// policyValue restituisce il valore del contatore indicato per una policy di backpressure
+func (m *recordingMeter) policyValue(name string, policy loggerhandler.BackpressurePolicy) int64 {
+	c := m.counter(name)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.byPolicy[policy.String()]
+}
+
+// failingSink rifiuta ogni scrittura
+type failingSink struct{ err error }
+
+func (s failingSink) Write([]byte) (int, error) { return 0, s.err }
+func (s failingSink) Flush() error              { return nil }
+func (s failingSink) Close() error              { return nil }
+
+func TestFailingSinkDoesNotSilenceOthers(t *testing.T) {
+	diskFull := errors.New("disk full")
+	logCfg := loggerhandler.NewLogConfigs(false, "", 1, 1, false)
+	errCfg := loggerhandler.NewLogConfigs(false, "", 1, 1, false)
+	broken := failingSink{err: diskFull}
+	healthy := loggerhandler.NewMemorySink()
+	logCfg.AddSink(broken)
+	logCfg.AddSink(healthy)
+
+	var mu sync.Mutex
+	var reported []error
+	logCfg.SetOnSinkError(func(sink loggerhandler.Sink, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if sink != broken {
+			t.Errorf("unexpected sink in callback: %T", sink)
+		}
+		reported = append(reported, err)
+	})
+
+	meter := &recordingMeter{}
+	lh := loggerhandler.NewLoggerHandler(logCfg, errCfg, meter, 10)
+	for i := 0; i < 2; i++ {
+		span := lh.AddSpan(0, nil, 5, slog.LevelError)
+		span.Info("still delivered")
+		span.ReleaseSuccess()
+	}
+	lh.Close()
+
+	if got := strings.Count(healthy.String(), `"msg":"still delivered"`); got != 2 {
+		t.Fatalf("expected healthy sink to receive both spans, got %d: %q", got, healthy.String())
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(reported) != 2 || !errors.Is(reported[0], diskFull) {
+		t.Fatalf("expected two reported errors, got %v", reported)
+	}
+	if got := meter.value("logger_sink_errors"); got != 2 {
+		t.Fatalf("expected logger_sink_errors=2, got %d", got)
+	}
+}
+
+func TestSinkErrorsFromMultiWriter(t *testing.T) {
+	cfg := loggerhandler.NewLogConfigs(false, "", 1, 1, false)
+	first := failingSink{err: errors.New("first")}
+	second := failingSink{err: errors.New("second")}
+	cfg.AddSink(first)
+	cfg.AddSink(second)
+
+	_, err := cfg.GetMultiWriter().Write([]byte("x"))
+	sinkErrs := loggerhandler.SinkErrors(err)
+	if len(sinkErrs) != 2 || sinkErrs[0].Sink != first || sinkErrs[1].Sink != second {
+		t.Fatalf("expected one SinkError per failing sink, got %v", sinkErrs)
+	}
+	if loggerhandler.SinkErrors(nil) != nil {
+		t.Fatal("expected no sink errors for nil")
+	}
+}
@@ -0,0 +1,106 @@
+package loggerhandler_test
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"testing"
+
+	loggerhandler "github.com/Mrpagio/logger-handler"
+)
+
+// helper: crea uno span con buffer da 3 record e la policy indicata, e vi scrive 5 record
+func fillSpanBuffer(t *testing.T, policy loggerhandler.SpanBufferPolicy) (*loggerhandler.LoggerHandler, *loggerhandler.SpanLogger, *loggerhandler.MemorySink, *recordingMeter) {
+	t.Helper()
+	sink := loggerhandler.NewMemorySink()
+	lh, meter := makeSinkTestHandler(t, 16, []loggerhandler.Sink{sink}, []loggerhandler.Sink{sink})
+	lh.SetSpanBufferPolicy(policy)
+
+	span := lh.AddSpan(0, nil, 3, slog.LevelError)
+	if span.GetBufferPolicy() != policy {
+		t.Fatalf("expected policy %s, got %s", policy, span.GetBufferPolicy())
+	}
+	for i := 1; i <= 5; i++ {
+		span.Info(fmt.Sprintf("record %d", i))
+	}
+	return lh, span, sink, meter
+}
+
+// helper: verifica che i frammenti compaiano nell'output nell'ordine dato
+func assertInOrder(t *testing.T, out string, parts ...string) {
+	t.Helper()
+	pos := 0
+	for _, p := range parts {
+		i := strings.Index(out[pos:], p)
+		if i < 0 {
+			t.Fatalf("expected %q after position %d in %q", p, pos, out)
+		}
+		pos += i + len(p)
+	}
+}
+
+func TestSpanBufferRingKeepsLastRecords(t *testing.T) {
+	lh, span, sink, meter := fillSpanBuffer(t, loggerhandler.SpanBufferRing)
+	span.ReleaseSuccess()
+	lh.Close()
+
+	out := sink.String()
+	if strings.Contains(out, "record 1") || strings.Contains(out, "record 2") {
+		t.Fatalf("oldest records must be dropped: %q", out)
+	}
+	assertInOrder(t, out, "OpType: ReleaseSuccess", `"msg":"2 records dropped","dropped":2,"policy":"Ring"`, "record 3", "record 4", "record 5")
+	if got := meter.value("logger_dropped_span_records"); got != 2 {
+		t.Fatalf("expected 2 dropped records, got %d", got)
+	}
+}
+
+func TestSpanBufferKeepFirstKeepsTerminalRecord(t *testing.T) {
+	lh, span, sink, meter := fillSpanBuffer(t, loggerhandler.SpanBufferKeepFirst)
+	span.Error("boom")
+	lh.Close()
+
+	out := sink.String()
+	if strings.Contains(out, "record 4") || strings.Contains(out, "record 5") {
+		t.Fatalf("newest records must be dropped: %q", out)
+	}
+	assertInOrder(t, out, "record 1", "record 2", "record 3", `"msg":"2 records dropped"`, `"msg":"boom"`)
+	if got := meter.value("logger_dropped_span_records"); got != 2 {
+		t.Fatalf("expected 2 dropped records, got %d", got)
+	}
+}
+
+func TestSpanBufferKeepFirstSendsTriggeringRecord(t *testing.T) {
+	sink := loggerhandler.NewMemorySink()
+	lh, _ := makeSinkTestHandler(t, 16, []loggerhandler.Sink{sink}, []loggerhandler.Sink{sink})
+	lh.SetSpanBufferPolicy(loggerhandler.SpanBufferKeepFirst)
+
+	span := lh.AddSpan(0, nil, 2, slog.LevelError)
+	span.Info("record 1")
+	span.Info("record 2")
+	span.Info("record 3")
+	// il buffer è pieno ma il record supera logLevel e fa inviare lo span
+	span.LogError("boom")
+	span.ReleaseSuccess()
+	lh.Close()
+
+	out := sink.String()
+	if strings.Contains(out, "record 3") {
+		t.Fatalf("newest non-triggering record must be dropped: %q", out)
+	}
+	assertInOrder(t, out, "OpType: Log", "record 1", "record 2", `"msg":"1 records dropped"`, `"msg":"boom"`)
+}
+
+func TestSpanBufferFlushEarlySendsOpLog(t *testing.T) {
+	lh, span, sink, meter := fillSpanBuffer(t, loggerhandler.SpanBufferFlushEarly)
+	span.ReleaseSuccess()
+	lh.Close()
+
+	out := sink.String()
+	if strings.Contains(out, "records dropped") {
+		t.Fatalf("flush early must not drop records: %q", out)
+	}
+	assertInOrder(t, out, "OpType: Log", "record 1", "record 3", "OpType: ReleaseSuccess", "record 4", "record 5")
+	if got := meter.value("logger_dropped_span_records"); got != 0 {
+		t.Fatalf("expected no dropped records, got %d", got)
+	}
+}
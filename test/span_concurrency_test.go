@@ -0,0 +1,90 @@
+package loggerhandler_test
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	loggerhandler "github.com/Mrpagio/logger-handler"
+)
+
+// helper: scrive sullo span da più goroutine in parallelo
+func logConcurrently(span *loggerhandler.SpanLogger, workers, perWorker int) {
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				span.Info("work", slog.Int("worker", w), slog.Int("i", i))
+				_ = span.LastSendResult()
+			}
+		}(w)
+	}
+	wg.Wait()
+}
+
+func TestSpanLoggerConcurrentBufferedRecords(t *testing.T) {
+	const workers, perWorker = 8, 200
+	sink := loggerhandler.NewMemorySink()
+	lh, _ := makeSinkTestHandler(t, 64, []loggerhandler.Sink{sink}, []loggerhandler.Sink{sink})
+	lh.SetBackpressurePolicy(loggerhandler.BackpressureBlock, 0)
+
+	span := lh.AddSpan(0, nil, 5, slog.LevelError)
+	logConcurrently(span, workers, perWorker)
+	span.ReleaseSuccess()
+	lh.Close()
+
+	out := sink.String()
+	if got := strings.Count(out, `"msg":"work"`); got != workers*perWorker {
+		t.Fatalf("expected %d records, got %d", workers*perWorker, got)
+	}
+	if got := strings.Count(out, "OpType: ReleaseSuccess"); got != 1 {
+		t.Fatalf("expected a single release, got %d", got)
+	}
+}
+
+func TestSpanLoggerConcurrentWithTimeout(t *testing.T) {
+	const workers, perWorker = 8, 200
+	sink := loggerhandler.NewMemorySink()
+	lh, _ := makeSinkTestHandler(t, 64, []loggerhandler.Sink{sink}, []loggerhandler.Sink{sink})
+	lh.SetBackpressurePolicy(loggerhandler.BackpressureBlock, 0)
+
+	// ogni record è inviato subito, mentre lo scheduler fa scadere lo span
+	span := lh.AddSpan(time.Millisecond, nil, 5, slog.LevelInfo)
+	logConcurrently(span, workers, perWorker)
+	waitSpanReleased(t, lh, span.GetID())
+	lh.Close()
+
+	out := sink.String()
+	if got := strings.Count(out, `"msg":"work"`); got != workers*perWorker {
+		t.Fatalf("expected %d records, got %d", workers*perWorker, got)
+	}
+	if got := strings.Count(out, "OpType: Timeout"); got != 1 {
+		t.Fatalf("expected a single timeout, got %d", got)
+	}
+}
+
+func TestSpanLoggerConcurrentWithShutdown(t *testing.T) {
+	sink := loggerhandler.NewMemorySink()
+	lh, _ := makeSinkTestHandler(t, 64, []loggerhandler.Sink{sink}, []loggerhandler.Sink{sink})
+	lh.SetBackpressurePolicy(loggerhandler.BackpressureBlock, 0)
+
+	span := lh.AddSpan(0, nil, 5, slog.LevelError)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		logConcurrently(span, 4, 200)
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+	defer cancel()
+	_ = lh.Shutdown(ctx)
+	<-done
+
+	if got := strings.Count(sink.String(), "OpType: Aborted"); got != 1 {
+		t.Fatalf("expected a single aborted output, got %d", got)
+	}
+}
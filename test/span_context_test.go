@@ -0,0 +1,52 @@
+package loggerhandler_test
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+
+	loggerhandler "github.com/Mrpagio/logger-handler"
+)
+
+func TestContextWithSpanRoundTrip(t *testing.T) {
+	if loggerhandler.SpanFromContext(context.Background()) != nil {
+		t.Fatal("expected no span in empty context")
+	}
+
+	lh := makeQuietTestHandler(t, 10)
+	defer lh.Close()
+
+	span := lh.AddSpan(0, nil, 5, slog.LevelDebug)
+	ctx := loggerhandler.ContextWithSpan(context.Background(), span)
+	if got := loggerhandler.SpanFromContext(ctx); got != span {
+		t.Fatalf("expected span %s from context, got %v", span.GetID(), got)
+	}
+}
+
+// deepCall simula una funzione in profondità nello stack che recupera lo span dal contesto
+func deepCall(ctx context.Context) {
+	loggerhandler.SpanFromContext(ctx).InfoContext(ctx, "from deep call", slog.String("where", "deep"))
+}
+
+func TestContextLoggingMethods(t *testing.T) {
+	lh, logPath, errPath := makeFileTestHandler(t, 10)
+
+	span := lh.AddSpan(0, nil, 5, slog.LevelError)
+	ctx := loggerhandler.ContextWithSpan(context.Background(), span)
+	span.DebugContext(ctx, "debug ctx")
+	deepCall(ctx)
+	span.WarnContext(ctx, "warn ctx")
+	span.ErrorContext(ctx, "error ctx")
+	lh.Close()
+
+	errOut := readLogFile(t, errPath)
+	for _, msg := range []string{"debug ctx", "from deep call", `"where":"deep"`, "warn ctx", "error ctx"} {
+		if !strings.Contains(errOut, msg) {
+			t.Fatalf("expected %q in error output, got %q", msg, errOut)
+		}
+	}
+	if out := readLogFile(t, logPath); out != "" {
+		t.Fatalf("expected empty log output, got %q", out)
+	}
+}
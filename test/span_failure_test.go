@@ -0,0 +1,97 @@
+package loggerhandler_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	loggerhandler "github.com/Mrpagio/logger-handler"
+)
+
+// errCaptureFormatter conserva l'errore dei comandi ricevuti e delega la scrittura al banner
+type errCaptureFormatter struct {
+	mu   sync.Mutex
+	errs []error
+}
+
+func (f *errCaptureFormatter) Format(ctx context.Context, w io.Writer, cmd loggerhandler.LogCommand) error {
+	f.mu.Lock()
+	f.errs = append(f.errs, cmd.Err)
+	f.mu.Unlock()
+	return loggerhandler.NewBannerFormatter().Format(ctx, w, cmd)
+}
+
+// helper: attende che il sink contenga il frammento indicato
+func waitSinkContains(t *testing.T, sink *loggerhandler.MemorySink, part string) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if strings.Contains(sink.String(), part) {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("expected %q in %q", part, sink.String())
+}
+
+func TestLogErrorKeepsSpanOpen(t *testing.T) {
+	sink := loggerhandler.NewMemorySink()
+	lh, meter := makeSinkTestHandler(t, 16, []loggerhandler.Sink{sink}, []loggerhandler.Sink{sink})
+
+	span := lh.AddSpan(0, nil, 10, slog.LevelError)
+	span.LogError("retry failed", slog.Int("attempt", 1))
+	// il worker ha elaborato il record di errore: lo span deve essere ancora aperto
+	waitSinkContains(t, sink, `"msg":"retry failed"`)
+	if _, open := lh.GetSpans()[span.GetID()]; !open {
+		t.Fatal("LogError must not release the span")
+	}
+	span.Info("retry succeeded")
+	span.ReleaseSuccess()
+	waitSpanReleased(t, lh, span.GetID())
+	lh.Close()
+
+	out := sink.String()
+	assertInOrder(t, out, "OpType: Log", `"msg":"retry failed","attempt":1`, "OpType: ReleaseSuccess", "retry succeeded")
+	if strings.Contains(out, "ReleaseFailure") {
+		t.Fatalf("unexpected failure output: %q", out)
+	}
+	if got := meter.value("logger_success_spans"); got != 1 {
+		t.Fatalf("expected the span to be released with success, got %d successes", got)
+	}
+	if meter.value("logger_failure_spans") != 0 || meter.value("logger_invalid_spans") != 0 {
+		t.Fatalf("expected no failures, got %d failures and %d invalid spans", meter.value("logger_failure_spans"), meter.value("logger_invalid_spans"))
+	}
+}
+
+func TestReleaseFailureKeepsErrorValue(t *testing.T) {
+	sink := loggerhandler.NewMemorySink()
+	lh, _ := makeSinkTestHandler(t, 10, []loggerhandler.Sink{sink}, []loggerhandler.Sink{sink})
+	formatter := &errCaptureFormatter{}
+	lh.GetErrorHandler().SetFormatter(formatter)
+
+	errNotFound := errors.New("not found")
+	span := lh.AddSpan(0, nil, 10, slog.LevelError)
+	span.ReleaseFailure(fmt.Errorf("load user: %w", errNotFound), slog.String("user", "42"))
+	empty := lh.AddSpan(0, nil, 10, slog.LevelError)
+	empty.ReleaseFailure(nil)
+	lh.Close()
+
+	formatter.mu.Lock()
+	defer formatter.mu.Unlock()
+	if len(formatter.errs) != 2 {
+		t.Fatalf("expected 2 failure commands, got %d", len(formatter.errs))
+	}
+	if !errors.Is(formatter.errs[0], errNotFound) {
+		t.Fatalf("expected the wrapped error to be preserved, got %v", formatter.errs[0])
+	}
+	if !errors.Is(formatter.errs[1], loggerhandler.ErrSpanFailed) {
+		t.Fatalf("expected ErrSpanFailed for a nil error, got %v", formatter.errs[1])
+	}
+	assertInOrder(t, sink.String(), "OpType: ReleaseFailure", `"msg":"load user: not found","user":"42"`)
+}
@@ -0,0 +1,86 @@
+package loggerhandler_test
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	loggerhandler "github.com/Mrpagio/logger-handler"
+)
+
+// helper: crea un LoggerHandler che scrive log ed errori su file nella directory temporanea del test
+func makeFileTestHandler(t *testing.T, bufferSize int) (*loggerhandler.LoggerHandler, string, string) {
+	t.Helper()
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "log.txt")
+	errPath := filepath.Join(dir, "err.txt")
+	logCfg := loggerhandler.NewLogConfigs(false, logPath, 1, 1, false)
+	errCfg := loggerhandler.NewLogConfigs(false, errPath, 1, 1, false)
+	lh := loggerhandler.NewLoggerHandler(logCfg, errCfg, &fakeMeter{}, bufferSize)
+	if lh == nil {
+		t.Fatal("NewLoggerHandler returned nil")
+	}
+	return lh, logPath, errPath
+}
+
+// helper: legge il contenuto di un file di log (stringa vuota se non esiste)
+func readLogFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatalf("read %s: %v", path, err)
+	}
+	return string(data)
+}
+
+func TestSpanHandlerWithSlogLogger(t *testing.T) {
+	lh, logPath, _ := makeFileTestHandler(t, 10)
+
+	span := lh.AddSpan(0, []string{"slog"}, 5, slog.LevelError)
+	logger := slog.New(span.Handler()).With("a", 1).WithGroup("g")
+	logger.Info("hello", "k", "v")
+	logger.WithGroup("empty").Debug("no attrs")
+	span.ReleaseSuccess()
+	lh.Close()
+
+	out := readLogFile(t, logPath)
+	if !strings.Contains(out, span.GetID()) {
+		t.Fatalf("expected span id in output, got %q", out)
+	}
+	if !strings.Contains(out, `"msg":"hello","a":1,"g":{"k":"v"}`) {
+		t.Fatalf("expected grouped attrs in output, got %q", out)
+	}
+	if !strings.Contains(out, `"msg":"no attrs","a":1,"trace_id"`) {
+		t.Fatalf("expected empty group to be omitted, got %q", out)
+	}
+}
+
+func TestSpanHandlerLevelRules(t *testing.T) {
+	lh, logPath, _ := makeFileTestHandler(t, 10)
+
+	span := lh.AddSpan(0, nil, 5, slog.LevelWarn)
+	handler := span.Handler()
+	if !handler.Enabled(context.Background(), slog.LevelDebug) {
+		t.Fatal("expected debug records to be accepted by the span handler")
+	}
+	logger := slog.New(handler)
+	logger.Info("buffered")
+	logger.Warn("flushed")
+	// un record Error dallo slog.Logger non deve rilasciare lo span
+	logger.Error("not a release")
+	lh.Close()
+
+	if _, ok := lh.GetSpans()[span.GetID()]; !ok {
+		t.Fatalf("span %s expected to be still open", span.GetID())
+	}
+	out := readLogFile(t, logPath)
+	if strings.Count(out, "OpType: Log") != 2 {
+		t.Fatalf("expected two OpLog blocks, got %q", out)
+	}
+	if !strings.Contains(out, "buffered") || !strings.Contains(out, "flushed") {
+		t.Fatalf("expected buffered and flushed records, got %q", out)
+	}
+}
@@ -2,6 +2,7 @@ package loggerhandler_test
 
 import (
 	"log/slog"
+	"strings"
 	"testing"
 	"time"
 
@@ -52,6 +53,54 @@ func TestSpanLoggerBasicAndCommands(t *testing.T) {
 	// ok if no panic and no deadlock
 }
 
+func TestSpanLoggerChild(t *testing.T) {
+	lh, logPath, errPath := makeFileTestHandler(t, 10)
+
+	parent := lh.AddSpan(0, []string{"request"}, 5, slog.LevelWarn)
+	db := parent.Child(0, "db")
+	if db.GetParentID() != parent.GetID() {
+		t.Fatalf("expected parent id %s, got %s", parent.GetID(), db.GetParentID())
+	}
+	if tags := db.GetTags(); len(tags) != 2 || tags[0] != "request" || tags[1] != "db" {
+		t.Fatalf("expected inherited tags, got %v", tags)
+	}
+	if db.GetLogLevel() != parent.GetLogLevel() || db.GetBufferSize() != parent.GetBufferSize() {
+		t.Fatal("expected child to inherit level and buffer size")
+	}
+	if parent.GetParentID() != "" {
+		t.Fatalf("expected root span without parent, got %s", parent.GetParentID())
+	}
+
+	db.Error("db failure")
+	waitSpanReleased(t, lh, db.GetID())
+	// il secondo figlio resta aperto al rilascio del padre
+	ext := parent.Child(0, "external")
+	parent.ReleaseSuccess()
+	waitSpanReleased(t, lh, parent.GetID())
+
+	if _, ok := lh.GetSpans()[ext.GetID()]; !ok {
+		t.Fatalf("orphaned child %s expected to stay open", ext.GetID())
+	}
+	ext.Info("external done")
+	ext.ReleaseSuccess()
+	lh.Close()
+
+	errOut := readLogFile(t, errPath)
+	if !strings.Contains(errOut, "Span ID: "+db.GetID()+" ----- Parent ID: "+parent.GetID()) {
+		t.Fatalf("expected parent reference in child header, got %q", errOut)
+	}
+	logOut := readLogFile(t, logPath)
+	if strings.Count(logOut, "Span ID: "+parent.GetID()) != 1 {
+		t.Fatalf("expected parent output to be written once, got %q", logOut)
+	}
+	if !strings.Contains(logOut, `"orphaned_children":["`+ext.GetID()+`"]`) {
+		t.Fatalf("expected orphaned child in parent output, got %q", logOut)
+	}
+	if !strings.Contains(logOut, "Span ID: "+ext.GetID()+" ----- Parent ID: "+parent.GetID()) {
+		t.Fatalf("expected parent reference in orphaned child header, got %q", logOut)
+	}
+}
+
 // test-only fake meter used by this file
 type testFakeCounter struct{}
 
@@ -0,0 +1,121 @@
+package loggerhandler_test
+
+import (
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	loggerhandler "github.com/Mrpagio/logger-handler"
+)
+
+func TestFlightRecorderDiscardsVerboseRecordsOnSuccess(t *testing.T) {
+	sink := loggerhandler.NewMemorySink()
+	lh, meter := makeSinkTestHandler(t, 16, []loggerhandler.Sink{sink}, []loggerhandler.Sink{sink})
+	lh.SetSpanMode(loggerhandler.SpanModeFlightRecorder)
+
+	// il livello Debug invierebbe subito ogni record in modalità immediata
+	span := lh.AddSpan(0, nil, 10, slog.LevelDebug)
+	if span.GetMode() != loggerhandler.SpanModeFlightRecorder {
+		t.Fatalf("expected flight recorder mode, got %s", span.GetMode())
+	}
+	span.Debug("query plan")
+	span.Info("cache miss")
+	span.Warn("slow upstream")
+	span.ReleaseSuccess()
+	lh.Close()
+
+	out := sink.String()
+	if strings.Contains(out, "query plan") || strings.Contains(out, "cache miss") {
+		t.Fatalf("verbose records must not be written on success: %q", out)
+	}
+	// i record da Warn in su seguono comunque logLevel e sono inviati subito
+	assertInOrder(t, out, "OpType: Log", `"msg":"slow upstream"`)
+	if got := meter.value("logger_flight_recorder_discarded_records"); got != 2 {
+		t.Fatalf("expected 2 discarded records, got %d", got)
+	}
+}
+
+func TestFlightRecorderSummaryOnSuccess(t *testing.T) {
+	sink := loggerhandler.NewMemorySink()
+	lh, _ := makeSinkTestHandler(t, 16, []loggerhandler.Sink{sink}, []loggerhandler.Sink{sink})
+	lh.SetSpanMode(loggerhandler.SpanModeFlightRecorderSummary)
+
+	span := lh.AddSpan(0, nil, 10, slog.LevelDebug)
+	span.Debug("a")
+	span.Debug("b")
+	span.Info("c")
+	span.ReleaseSuccess()
+	lh.Close()
+
+	if !strings.Contains(sink.String(), `"msg":"3 debug/info records discarded","debug":2,"info":1`) {
+		t.Fatalf("expected summary line, got %q", sink.String())
+	}
+}
+
+func TestFlightRecorderWritesEverythingOnFailure(t *testing.T) {
+	sink := loggerhandler.NewMemorySink()
+	lh, _ := makeSinkTestHandler(t, 16, []loggerhandler.Sink{sink}, []loggerhandler.Sink{sink})
+	lh.SetSpanMode(loggerhandler.SpanModeFlightRecorder)
+
+	failed := lh.AddSpan(0, nil, 10, slog.LevelDebug)
+	failed.Debug("query plan")
+	failed.Info("cache miss")
+	child := failed.Child(10 * time.Millisecond)
+	if child.GetMode() != loggerhandler.SpanModeFlightRecorder {
+		t.Fatalf("child must inherit the parent mode, got %s", child.GetMode())
+	}
+	child.Debug("child detail")
+	failed.Error("boom")
+	waitSpanReleased(t, lh, child.GetID())
+	lh.Close()
+
+	out := sink.String()
+	assertInOrder(t, out, "OpType: ReleaseFailure", "query plan", "cache miss", `"msg":"boom"`)
+	assertInOrder(t, out, "OpType: Timeout", "child detail", "Span timeout reached")
+}
+
+func TestFlightRecorderRingKeepsLatestContext(t *testing.T) {
+	sink := loggerhandler.NewMemorySink()
+	lh, _ := makeSinkTestHandler(t, 16, []loggerhandler.Sink{sink}, []loggerhandler.Sink{sink})
+	lh.SetSpanMode(loggerhandler.SpanModeFlightRecorder)
+
+	// con la policy predefinita FlushEarly il buffer pieno non deve inviare nulla
+	span := lh.AddSpan(0, nil, 2, slog.LevelDebug)
+	span.Debug("first")
+	span.Debug("second")
+	span.Debug("third")
+	span.Error("boom")
+	lh.Close()
+
+	out := sink.String()
+	if strings.Contains(out, "OpType: Log") || strings.Contains(out, `"msg":"first"`) {
+		t.Fatalf("flight recorder must keep only the latest records: %q", out)
+	}
+	assertInOrder(t, out, `"msg":"1 records dropped","dropped":1,"policy":"Ring"`, "second", "third", "boom")
+}
+
+func TestFlightRecorderSendsWarningsImmediately(t *testing.T) {
+	sink := loggerhandler.NewMemorySink()
+	lh, _ := makeSinkTestHandler(t, 16, []loggerhandler.Sink{sink}, []loggerhandler.Sink{sink})
+	lh.SetSpanMode(loggerhandler.SpanModeFlightRecorder)
+
+	span := lh.AddSpan(0, nil, 10, slog.LevelWarn)
+	span.Debug("query plan")
+	span.Warn("slow upstream")
+
+	// sotto logLevel anche un Warn resta nello span fino al rilascio
+	quiet := lh.AddSpan(0, nil, 10, slog.LevelError)
+	quiet.Warn("retrying")
+	span.Error("boom")
+	quiet.ReleaseSuccess()
+	lh.Close()
+
+	out := sink.String()
+	if got := strings.Count(out, "slow upstream"); got != 1 {
+		t.Fatalf("expected the warning to be written once, got %d: %q", got, out)
+	}
+	// il warning precede i record trattenuti, scritti solo al fallimento
+	assertInOrder(t, out, "OpType: Log", "slow upstream", "OpType: ReleaseFailure", "query plan", `"msg":"boom"`)
+	assertInOrder(t, out, "OpType: ReleaseSuccess", "retrying")
+}
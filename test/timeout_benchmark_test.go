@@ -0,0 +1,142 @@
+//go:build unix
+
+package loggerhandler_test
+
+import (
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	loggerhandler "github.com/Mrpagio/logger-handler"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// I benchmark di questo file usano solo l'API presente anche prima dello scheduler dei
+// timeout (un time.AfterFunc per span), così il confronto avviene con il codice originale
+// e non con una sua copia. Per confrontare le due implementazioni:
+//
+//	go test ./test -run '^$' -bench Timeout -count 10 > new.txt
+//	git worktree add /tmp/old <commit precedente allo scheduler>
+//	cp test/timeout_benchmark_test.go /tmp/old/test/
+//	(cd /tmp/old && go test ./test -run '^$' -bench Timeout -count 10) > old.txt
+//	benchstat old.txt new.txt
+//
+// Oltre a tempo e allocazioni ogni benchmark riporta cpu-ns/op, il tempo CPU del processo
+// (utente e sistema, di tutte le goroutine) diviso per il numero di operazioni.
+
+// timeoutBenchMeter conta gli span chiusi con un errore, compresi quelli scaduti
+type timeoutBenchMeter struct {
+	failures atomic.Int64
+}
+
+// timeoutBenchCounter somma i valori in un contatore atomico (nil per scartarli)
+type timeoutBenchCounter struct {
+	value *atomic.Int64
+}
+
+func (c timeoutBenchCounter) Add(v int64, _ ...metric.AddOption) {
+	if c.value != nil {
+		c.value.Add(v)
+	}
+}
+
+func (m *timeoutBenchMeter) Int64Counter(name string, _ ...metric.InstrumentOption) (loggerhandler.Int64CounterLike, error) {
+	if name == "logger_failure_spans" {
+		return timeoutBenchCounter{value: &m.failures}, nil
+	}
+	return timeoutBenchCounter{}, nil
+}
+
+func (m *timeoutBenchMeter) Int64UpDownCounter(string, ...metric.InstrumentOption) (loggerhandler.Int64UpDownCounterLike, error) {
+	return timeoutBenchCounter{}, nil
+}
+
+// processCPUTime restituisce il tempo CPU consumato finora dal processo
+func processCPUTime(b *testing.B) time.Duration {
+	b.Helper()
+	var ru syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &ru); err != nil {
+		b.Fatalf("getrusage: %v", err)
+	}
+	return time.Duration(ru.Utime.Nano() + ru.Stime.Nano())
+}
+
+// helper: crea un handler silenzioso con outstanding span in attesa di un timeout lontano
+// e misura il tempo CPU del benchmark da questo momento
+func newTimeoutBenchHandler(b *testing.B, outstanding int) (*loggerhandler.LoggerHandler, *timeoutBenchMeter, func()) {
+	b.Helper()
+	meter := &timeoutBenchMeter{}
+	lh := loggerhandler.NewLoggerHandler(loggerhandler.NewLogConfigs(false, "", 1, 1, false), loggerhandler.NewLogConfigs(false, "", 1, 1, false), meter, 1024)
+	for i := 0; i < outstanding; i++ {
+		lh.AddSpan(time.Hour, nil, 5, slog.LevelError)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	start := processCPUTime(b)
+	stop := func() {
+		b.StopTimer()
+		b.ReportMetric(float64(processCPUTime(b)-start)/float64(b.N), "cpu-ns/op")
+		lh.Close()
+	}
+	return lh, meter, stop
+}
+
+// BenchmarkTimeoutAddRemove misura la creazione e il rilascio di span con timeout
+func BenchmarkTimeoutAddRemove(b *testing.B) {
+	for _, outstanding := range []int{0, 100_000} {
+		b.Run(fmt.Sprintf("outstanding=%d", outstanding), func(b *testing.B) {
+			lh, _, stop := newTimeoutBenchHandler(b, outstanding)
+			defer stop()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					lh.RemoveSpan(lh.AddSpan(time.Hour, nil, 5, slog.LevelError).GetID())
+				}
+			})
+		})
+	}
+}
+
+// BenchmarkTimeoutFire misura la scadenza degli span: pianificazione, notifica ed
+// elaborazione dell'OpTimeout. Gli span scadono a blocchi di 100, meno dei 128 posti del
+// canale dei timer usato prima dello scheduler, così nessun timeout viene perso.
+func BenchmarkTimeoutFire(b *testing.B) {
+	const batch = 100
+	for _, outstanding := range []int{0, 100_000} {
+		b.Run(fmt.Sprintf("outstanding=%d", outstanding), func(b *testing.B) {
+			lh, meter, stop := newTimeoutBenchHandler(b, outstanding)
+			defer stop()
+			for fired := 0; fired < b.N; {
+				n := min(batch, b.N-fired)
+				for i := 0; i < n; i++ {
+					lh.AddSpan(time.Millisecond, nil, 5, slog.LevelError)
+				}
+				fired += n
+				deadline := time.Now().Add(5 * time.Second)
+				for meter.failures.Load() < int64(fired) {
+					if time.Now().After(deadline) {
+						b.Fatalf("only %d of %d timeouts fired", meter.failures.Load(), fired)
+					}
+					time.Sleep(100 * time.Microsecond)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkTimeoutIdle misura il costo degli span in attesa: ogni operazione è 1ms senza
+// attività, quindi cpu-ns/op è il tempo CPU speso per tenere aperti outstanding timeout
+func BenchmarkTimeoutIdle(b *testing.B) {
+	for _, outstanding := range []int{0, 100_000} {
+		b.Run(fmt.Sprintf("outstanding=%d", outstanding), func(b *testing.B) {
+			_, _, stop := newTimeoutBenchHandler(b, outstanding)
+			defer stop()
+			for i := 0; i < b.N; i++ {
+				time.Sleep(time.Millisecond)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package loggerhandler_test
+
+import (
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	loggerhandler "github.com/Mrpagio/logger-handler"
+)
+
+// helper: attende che il LoggerHandler non abbia più span aperti
+func waitNoSpans(t *testing.T, lh *loggerhandler.LoggerHandler, within time.Duration) {
+	t.Helper()
+	deadline := time.Now().Add(within)
+	for time.Now().Before(deadline) {
+		if len(lh.GetSpans()) == 0 {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("still %d open spans", len(lh.GetSpans()))
+}
+
+func TestEveryTimeoutFiresExactlyOnce(t *testing.T) {
+	// più span di quanti ne conteneva il vecchio canale dei timer (128)
+	const n = 2000
+	errSink := loggerhandler.NewMemorySink()
+	lh, meter := makeSinkTestHandler(t, 16, nil, []loggerhandler.Sink{errSink})
+	defer lh.Close()
+	// con la policy predefinita (BackpressureDropNewest) nessun timeout deve essere scartato
+
+	for i := 0; i < n; i++ {
+		lh.AddSpan(20*time.Millisecond, nil, 5, slog.LevelError).Info("waiting")
+	}
+	waitNoSpans(t, lh, 5*time.Second)
+
+	if got := strings.Count(errSink.String(), "OpType: Timeout"); got != n {
+		t.Fatalf("expected %d timeouts, got %d", n, got)
+	}
+	if got := meter.value("logger_failure_spans"); got != n {
+		t.Fatalf("expected %d failures, got %d", n, got)
+	}
+	if got := meter.value("logger_invalid_spans"); got != 0 {
+		t.Fatalf("expected no invalid spans, got %d", got)
+	}
+}
+
+func TestReleasedSpanTimeoutDoesNotFire(t *testing.T) {
+	errSink := loggerhandler.NewMemorySink()
+	lh, meter := makeSinkTestHandler(t, 16, nil, []loggerhandler.Sink{errSink})
+	defer lh.Close()
+
+	early := lh.AddSpan(time.Hour, nil, 5, slog.LevelError)
+	span := lh.AddSpan(30*time.Millisecond, nil, 5, slog.LevelError)
+	span.ReleaseSuccess()
+	late := lh.AddSpan(10*time.Millisecond, nil, 5, slog.LevelError)
+	waitSpanReleased(t, lh, late.GetID())
+	time.Sleep(50 * time.Millisecond)
+
+	if strings.Contains(errSink.String(), span.GetID()) {
+		t.Fatalf("released span must not time out: %q", errSink.String())
+	}
+	if _, open := lh.GetSpans()[early.GetID()]; !open {
+		t.Fatal("span with a later deadline must still be open")
+	}
+	if got := meter.value("logger_invalid_spans"); got != 0 {
+		t.Fatalf("expected no invalid spans, got %d", got)
+	}
+}
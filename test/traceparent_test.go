@@ -0,0 +1,169 @@
+package loggerhandler_test
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+
+	loggerhandler "github.com/Mrpagio/logger-handler"
+)
+
+func TestParseTraceparentTable(t *testing.T) {
+	cases := []struct {
+		name    string
+		value   string
+		valid   bool
+		sampled bool
+	}{
+		{"sampled", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true, true},
+		{"not-sampled", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", true, false},
+		{"future-version", "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", true, true},
+		{"forbidden-version", "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false, false},
+		{"zero-trace", "00-00000000000000000000000000000000-00f067aa0ba902b7-01", false, false},
+		{"zero-span", "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false, false},
+		{"uppercase", "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", false, false},
+		{"extra-fields-v00", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-x", false, false},
+		{"garbage", "not-a-header", false, false},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			traceID, parentID, sampled, err := loggerhandler.ParseTraceparent(c.value)
+			if !c.valid {
+				if !errors.Is(err, loggerhandler.ErrInvalidTraceparent) {
+					t.Fatalf("expected ErrInvalidTraceparent, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if traceID != "4bf92f3577b34da6a3ce929d0e0e4736" || parentID != "00f067aa0ba902b7" || sampled != c.sampled {
+				t.Fatalf("unexpected parse result %s %s %v", traceID, parentID, sampled)
+			}
+		})
+	}
+}
+
+func TestTraceContextRoundTrip(t *testing.T) {
+	lh := makeQuietTestHandler(t, 10)
+	defer lh.Close()
+
+	// servizio chiamante
+	client := lh.AddSpan(0, []string{"client"}, 5, slog.LevelDebug)
+	header := http.Header{}
+	if err := client.InjectTraceContext(header); err != nil {
+		t.Fatalf("inject: %v", err)
+	}
+	value := header.Get(loggerhandler.TraceparentHeader)
+	if !strings.HasPrefix(value, "00-"+client.GetTraceID()+"-") || !strings.HasSuffix(value, "-01") {
+		t.Fatalf("unexpected traceparent %q", value)
+	}
+	// lo span ID W3C corrisponde alle ultime 16 cifre del UUID dello span
+	compact := strings.ReplaceAll(client.GetID(), "-", "")
+	if !strings.Contains(value, "-"+compact[16:]+"-") {
+		t.Fatalf("expected span id %s in %q", compact[16:], value)
+	}
+
+	// servizio chiamato
+	header.Set(loggerhandler.TracestateHeader, "vendor=value")
+	server, err := lh.AddSpanFromTraceContext(header, 0, []string{"server"}, 5, slog.LevelDebug)
+	if err != nil {
+		t.Fatalf("extract: %v", err)
+	}
+	if server.GetTraceID() != client.GetTraceID() {
+		t.Fatalf("expected trace id %s, got %s", client.GetTraceID(), server.GetTraceID())
+	}
+	if server.GetParentID() != compact[16:] {
+		t.Fatalf("expected parent id %s, got %s", compact[16:], server.GetParentID())
+	}
+
+	// il figlio eredita tracestate e lo propaga alla chiamata successiva
+	child := server.Child(0)
+	next := http.Header{}
+	if err := child.InjectTraceContext(next); err != nil {
+		t.Fatalf("inject child: %v", err)
+	}
+	if next.Get(loggerhandler.TracestateHeader) != "vendor=value" {
+		t.Fatalf("expected tracestate to be propagated, got %q", next.Get(loggerhandler.TracestateHeader))
+	}
+}
+
+func TestAddSpanFromInvalidTraceContext(t *testing.T) {
+	lh := makeQuietTestHandler(t, 10)
+	defer lh.Close()
+
+	header := http.Header{}
+	header.Set(loggerhandler.TraceparentHeader, "00-bad")
+	span, err := lh.AddSpanFromTraceContext(header, 0, nil, 5, slog.LevelDebug)
+	if !errors.Is(err, loggerhandler.ErrInvalidTraceparent) {
+		t.Fatalf("expected ErrInvalidTraceparent, got %v", err)
+	}
+	if span == nil || span.GetParentID() != "" || span.GetTraceID() == "" {
+		t.Fatal("expected a new root span on invalid header")
+	}
+
+	// nessun header: nuovo span radice senza errore
+	span, err = lh.AddSpanFromTraceContext(http.Header{}, 0, nil, 5, slog.LevelDebug)
+	if err != nil || span.GetParentID() != "" {
+		t.Fatalf("expected root span without error, got %v", err)
+	}
+
+	// trace ID fornito non rappresentabile nel formato W3C
+	custom := lh.AddSpanWithTraceID("request-42", 0, nil, 5, slog.LevelDebug)
+	if _, err := custom.Traceparent(); !errors.Is(err, loggerhandler.ErrInvalidTraceparent) {
+		t.Fatalf("expected ErrInvalidTraceparent for custom trace id, got %v", err)
+	}
+}
+
+// helper: estrae dall'header del banner dello span il valore del campo indicato
+func bannerField(t *testing.T, out, spanID, field string) string {
+	t.Helper()
+	for _, line := range strings.Split(out, "\n") {
+		if !strings.Contains(line, " Span ID: "+spanID+" -----") {
+			continue
+		}
+		_, rest, found := strings.Cut(line, " "+field+": ")
+		if !found {
+			t.Fatalf("field %s missing in %q", field, line)
+		}
+		value, _, _ := strings.Cut(rest, " ")
+		return value
+	}
+	t.Fatalf("banner of span %s not found in %q", spanID, out)
+	return ""
+}
+
+func TestTraceContextParentMatchesCallerOutput(t *testing.T) {
+	sink := loggerhandler.NewMemorySink()
+	lh, _ := makeSinkTestHandler(t, 64, []loggerhandler.Sink{sink}, []loggerhandler.Sink{sink})
+	lh.SetBackpressurePolicy(loggerhandler.BackpressureBlock, 0)
+
+	client := lh.AddSpan(0, []string{"client"}, 5, slog.LevelDebug)
+	header := http.Header{}
+	if err := client.InjectTraceContext(header); err != nil {
+		t.Fatalf("inject: %v", err)
+	}
+	client.Info("calling server")
+	client.ReleaseSuccess()
+
+	server, err := lh.AddSpanFromTraceContext(header, 0, []string{"server"}, 5, slog.LevelDebug)
+	if err != nil {
+		t.Fatalf("extract: %v", err)
+	}
+	server.Info("handling request")
+	server.ReleaseSuccess()
+	lh.Close()
+
+	out := sink.String()
+	callerID := bannerField(t, out, client.GetID(), "W3C Span ID")
+	if parentID := bannerField(t, out, server.GetID(), "Parent ID"); parentID != callerID {
+		t.Fatalf("expected server parent id %s to match the caller output, got %s", callerID, parentID)
+	}
+	if !strings.Contains(out, `"msg":"calling server","trace_id":"`+client.GetTraceID()+`","span_id":"`+client.GetID()+`","w3c_span_id":"`+callerID+`"`) {
+		t.Fatalf("expected caller records to carry the W3C span id, got %q", out)
+	}
+}
@@ -0,0 +1,286 @@
+package loggerhandler_test
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	loggerhandler "github.com/Mrpagio/logger-handler"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// fakeTraceSpan è uno span di tracing in memoria usato nei test
+type fakeTraceSpan struct {
+	mu     sync.Mutex
+	name   string
+	sc     trace.SpanContext
+	parent trace.SpanContext
+	code   codes.Code
+	desc   string
+	errs   []error
+	ended  bool
+}
+
+func (s *fakeTraceSpan) SpanContext() trace.SpanContext { return s.sc }
+
+func (s *fakeTraceSpan) SetStatus(code codes.Code, description string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.code, s.desc = code, description
+}
+
+func (s *fakeTraceSpan) RecordError(err error, _ ...trace.EventOption) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.errs = append(s.errs, err)
+}
+
+func (s *fakeTraceSpan) End(_ ...trace.SpanEndOption) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ended = true
+}
+
+// fakeTracer implementa TracerInterface tenendo in memoria gli span avviati
+type fakeTracer struct {
+	mu    sync.Mutex
+	spans []*fakeTraceSpan
+}
+
+func (f *fakeTracer) Start(ctx context.Context, name string, _ ...trace.SpanStartOption) (context.Context, loggerhandler.TraceSpanLike) {
+	parent := trace.SpanContextFromContext(ctx)
+	var traceID trace.TraceID
+	var spanID trace.SpanID
+	_, _ = rand.Read(traceID[:])
+	_, _ = rand.Read(spanID[:])
+	if parent.IsValid() {
+		traceID = parent.TraceID()
+	}
+	sp := &fakeTraceSpan{
+		name:   name,
+		parent: parent,
+		sc: trace.NewSpanContext(trace.SpanContextConfig{
+			TraceID:    traceID,
+			SpanID:     spanID,
+			TraceFlags: trace.FlagsSampled,
+		}),
+	}
+	f.mu.Lock()
+	f.spans = append(f.spans, sp)
+	f.mu.Unlock()
+	return trace.ContextWithSpanContext(ctx, sp.sc), sp
+}
+
+// find restituisce lo span di tracing con lo span ID indicato
+func (f *fakeTracer) find(t *testing.T, traceparent string) *fakeTraceSpan {
+	t.Helper()
+	_, spanID, _, err := loggerhandler.ParseTraceparent(traceparent)
+	if err != nil {
+		t.Fatalf("parse traceparent: %v", err)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, sp := range f.spans {
+		if sp.sc.SpanID().String() == spanID {
+			return sp
+		}
+	}
+	t.Fatalf("trace span %s not found", spanID)
+	return nil
+}
+
+func TestTracerBridgeStatusAndEnd(t *testing.T) {
+	lh, logPath, errPath := makeFileTestHandler(t, 10)
+	tracer := &fakeTracer{}
+	lh.SetTracer(tracer)
+	if lh.GetTracer() == nil {
+		t.Fatal("expected tracer to be configured")
+	}
+
+	ok := lh.AddSpan(0, []string{"ok-op"}, 5, slog.LevelDebug)
+	failed := lh.AddSpan(0, []string{"failed-op"}, 5, slog.LevelDebug)
+	child := failed.Child(0, "db")
+	timedOut := lh.AddSpan(0, nil, 5, slog.LevelDebug)
+
+	tpOk, _ := ok.Traceparent()
+	tpFailed, _ := failed.Traceparent()
+	tpChild, _ := child.Traceparent()
+	tpTimedOut, _ := timedOut.Traceparent()
+
+	ok.Info("all good")
+	ok.ReleaseSuccess()
+	child.ReleaseSuccess()
+	failed.Error("boom")
+	timedOut.Timeout()
+	lh.Close()
+
+	okSpan := tracer.find(t, tpOk)
+	if okSpan.name != "ok-op" || !okSpan.ended || okSpan.code != codes.Ok {
+		t.Fatalf("unexpected ok span state: %+v", okSpan)
+	}
+	if ok.GetTraceID() != okSpan.sc.TraceID().String() {
+		t.Fatalf("expected span to adopt otel trace id, got %s", ok.GetTraceID())
+	}
+	failedSpan := tracer.find(t, tpFailed)
+	if !failedSpan.ended || failedSpan.code != codes.Error || failedSpan.desc != "boom" || len(failedSpan.errs) != 1 {
+		t.Fatalf("unexpected failed span state: %+v", failedSpan)
+	}
+	childSpan := tracer.find(t, tpChild)
+	if childSpan.parent.SpanID() != failedSpan.sc.SpanID() || childSpan.sc.TraceID() != failedSpan.sc.TraceID() {
+		t.Fatal("expected child trace span to be a child of the parent trace span")
+	}
+	if timedOutSpan := tracer.find(t, tpTimedOut); !timedOutSpan.ended || timedOutSpan.code != codes.Error {
+		t.Fatalf("unexpected timeout span state: %+v", timedOutSpan)
+	}
+
+	// i record riportano trace_id e span_id dello span di tracing
+	logOut := readLogFile(t, logPath)
+	wantAttrs := `"trace_id":"` + okSpan.sc.TraceID().String() + `","span_id":"` + okSpan.sc.SpanID().String() + `"`
+	if !strings.Contains(logOut, wantAttrs) {
+		t.Fatalf("expected %s in output, got %q", wantAttrs, logOut)
+	}
+	if !strings.Contains(readLogFile(t, errPath), `"span_id":"`+failedSpan.sc.SpanID().String()+`"`) {
+		t.Fatal("expected otel span id in failure output")
+	}
+}
+
+func TestTracerBridgeRemoteParent(t *testing.T) {
+	lh := makeQuietTestHandler(t, 10)
+	defer lh.Close()
+	tracer := &fakeTracer{}
+	lh.SetTracer(tracer)
+
+	header := http.Header{}
+	header.Set(loggerhandler.TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	span, err := lh.AddSpanFromTraceContext(header, 0, nil, 5, slog.LevelDebug)
+	if err != nil {
+		t.Fatalf("extract: %v", err)
+	}
+	if span.GetTraceID() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Fatalf("expected remote trace id, got %s", span.GetTraceID())
+	}
+	tp, _ := span.Traceparent()
+	sp := tracer.find(t, tp)
+	if !sp.parent.IsRemote() || sp.parent.SpanID().String() != "00f067aa0ba902b7" {
+		t.Fatalf("expected remote parent span context, got %+v", sp.parent)
+	}
+}
+
+func TestAddSpanContextAdoptsOtelTrace(t *testing.T) {
+	lh, logPath, _ := makeFileTestHandler(t, 10)
+
+	traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spanID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	ctx := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     spanID,
+		TraceFlags: trace.FlagsSampled,
+	}))
+
+	span := lh.AddSpanContext(ctx, 0, nil, 5, slog.LevelDebug)
+	if span.GetTraceID() != traceID.String() {
+		t.Fatalf("expected trace id %s, got %s", traceID, span.GetTraceID())
+	}
+	if span.GetParentID() != spanID.String() {
+		t.Fatalf("expected otel span id as parent, got %s", span.GetParentID())
+	}
+
+	// senza span di tracing nel contesto viene creata una nuova trace
+	plain := lh.AddSpanContext(context.Background(), 0, nil, 5, slog.LevelDebug)
+	if plain.GetParentID() != "" || plain.GetTraceID() == traceID.String() {
+		t.Fatal("expected a new root span without otel context")
+	}
+
+	span.Info("correlated")
+	span.ReleaseSuccess()
+	plain.Info("plain")
+	plain.ReleaseSuccess()
+	lh.Close()
+
+	out := readLogFile(t, logPath)
+	want := `"msg":"correlated","trace_id":"` + traceID.String() + `","span_id":"` + span.GetID() + `"`
+	if !strings.Contains(out, want) {
+		t.Fatalf("expected %s in output, got %q", want, out)
+	}
+	if !strings.Contains(out, `"msg":"plain","trace_id":"`+plain.GetTraceID()+`","span_id":"`+plain.GetID()+`"`) {
+		t.Fatalf("expected trace_id/span_id on every record, got %q", out)
+	}
+}
+
+func TestAddSpanContextWithTracer(t *testing.T) {
+	lh := makeQuietTestHandler(t, 10)
+	defer lh.Close()
+	tracer := &fakeTracer{}
+	lh.SetTracer(tracer)
+
+	ctx, parent := tracer.Start(context.Background(), "http")
+	span := lh.AddSpanContext(ctx, 0, nil, 5, slog.LevelDebug)
+	tp, _ := span.Traceparent()
+	sp := tracer.find(t, tp)
+	if sp.parent.SpanID() != parent.SpanContext().SpanID() || sp.parent.IsRemote() {
+		t.Fatalf("expected trace span to be a child of the context span, got %+v", sp.parent)
+	}
+	if span.GetTraceID() != parent.SpanContext().TraceID().String() {
+		t.Fatalf("expected context trace id, got %s", span.GetTraceID())
+	}
+}
+
+func TestTracerBridgeKeepsSuppliedTraceID(t *testing.T) {
+	lh := makeQuietTestHandler(t, 10)
+	defer lh.Close()
+	tracer := &fakeTracer{}
+	lh.SetTracer(tracer)
+
+	span := lh.AddSpanWithTraceID("4bf92f3577b34da6a3ce929d0e0e4736", 0, nil, 5, slog.LevelDebug)
+	if span.GetTraceID() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Fatalf("expected supplied trace id to be kept, got %s", span.GetTraceID())
+	}
+	tp, _ := span.Traceparent()
+	sp := tracer.find(t, tp)
+	if sp.sc.TraceID().String() != span.GetTraceID() || !sp.parent.IsRemote() {
+		t.Fatalf("expected trace span in the supplied trace with a remote parent, got %+v", sp.sc)
+	}
+
+	// un trace ID non W3C non può essere adottato dallo span di tracing, ma resta quello dei log
+	custom := lh.AddSpanWithTraceID("request-42", 0, nil, 5, slog.LevelDebug)
+	if custom.GetTraceID() != "request-42" {
+		t.Fatalf("expected custom trace id to be kept, got %s", custom.GetTraceID())
+	}
+}
+
+func TestTracerBridgeEndsSpanAtRelease(t *testing.T) {
+	lh, _, sink, _ := makeStalledHandler(t, loggerhandler.BackpressureDropNewest, 0)
+	tracer := &fakeTracer{}
+	lh.SetTracer(tracer)
+
+	// il worker è fermo: lo span di tracing va chiuso senza attendere l'elaborazione
+	failed := lh.AddSpan(0, nil, 5, slog.LevelDebug)
+	tpFailed, _ := failed.Traceparent()
+	failed.ReleaseFailure(errors.New("boom"))
+	if sp := tracer.find(t, tpFailed); !sp.ended || sp.code != codes.Error || sp.desc != "boom" {
+		t.Fatalf("expected trace span to be ended at release, got %+v", sp)
+	}
+	failed.ReleaseSuccess()
+	if sp := tracer.find(t, tpFailed); sp.code != codes.Error {
+		t.Fatalf("expected a second release not to change the trace span, got %+v", sp)
+	}
+
+	// un rilascio rifiutato dopo Close chiude comunque lo span di tracing
+	late := lh.AddSpan(0, nil, 5, slog.LevelDebug)
+	tpLate, _ := late.Traceparent()
+	sink.release()
+	lh.Close()
+	late.ReleaseSuccess()
+	if late.LastSendResult() != loggerhandler.SendRejectedClosed {
+		t.Fatalf("expected the release to be rejected, got %s", late.LastSendResult())
+	}
+	if sp := tracer.find(t, tpLate); !sp.ended || sp.code != codes.Ok {
+		t.Fatalf("expected rejected release to end the trace span, got %+v", sp)
+	}
+}
@@ -0,0 +1,266 @@
+package loggerhandler_test
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	loggerhandler "github.com/Mrpagio/logger-handler"
+)
+
+// helper: attende che lo span venga rimosso dal LoggerHandler
+func waitSpanReleased(t *testing.T, lh *loggerhandler.LoggerHandler, id string) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if _, open := lh.GetSpans()[id]; !open {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("span %s not released", id)
+}
+
+// helper: simula il crash del processo copiando i file del write-ahead log in una nuova
+// directory, dove nessun processo ne detiene il lock
+func crashWALDir(t *testing.T, dir string) string {
+	t.Helper()
+	crashed := t.TempDir()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("read wal dir: %v", err)
+	}
+	for _, entry := range entries {
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			t.Fatalf("read %s: %v", entry.Name(), err)
+		}
+		if err := os.WriteFile(filepath.Join(crashed, entry.Name()), data, 0o644); err != nil {
+			t.Fatalf("copy %s: %v", entry.Name(), err)
+		}
+	}
+	return crashed
+}
+
+// helper: conta i segmenti del write-ahead log presenti nella directory
+func walSegments(t *testing.T, dir string) int {
+	t.Helper()
+	segments, err := filepath.Glob(filepath.Join(dir, "wal-*.log"))
+	if err != nil {
+		t.Fatalf("glob: %v", err)
+	}
+	return len(segments)
+}
+
+// helper: attende che nella directory restino al più n segmenti
+func waitWALSegments(t *testing.T, dir string, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if walSegments(t, dir) <= n {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("expected at most %d segments, got %d", n, walSegments(t, dir))
+}
+
+// helper: crea un LoggerHandler con write-ahead log che scrive gli errori su errSink
+func makeWALHandler(t *testing.T, opts loggerhandler.HandlerOptions, meter loggerhandler.MeterInterface, errSink loggerhandler.Sink) *loggerhandler.LoggerHandler {
+	t.Helper()
+	errCfg := loggerhandler.NewLogConfigs(false, "", 1, 1, false)
+	if errSink != nil {
+		errCfg.AddSink(errSink)
+	}
+	lh := loggerhandler.NewLoggerHandlerWithOptions(loggerhandler.NewLogConfigs(false, "", 1, 1, false), errCfg, meter, 10, opts)
+	if err := lh.WALError(); err != nil {
+		t.Fatalf("wal: %v", err)
+	}
+	return lh
+}
+
+func TestWALReplaysSpansLostOnCrash(t *testing.T) {
+	dir := t.TempDir()
+
+	// primo processo: "crasha" senza chiamare Close
+	crashed := makeWALHandler(t, loggerhandler.HandlerOptions{WALDir: dir}, &fakeMeter{}, nil)
+	pending := crashed.AddSpan(0, []string{"checkout"}, 5, slog.LevelError)
+	pending.Info("before crash", slog.String("order", "42"))
+	half := crashed.AddSpan(0, nil, 5, slog.LevelInfo)
+	half.Info("already written")
+	half.Debug("still buffered")
+	done := crashed.AddSpan(0, nil, 5, slog.LevelError)
+	done.Info("completed")
+	done.ReleaseSuccess()
+	waitSpanReleased(t, crashed, done.GetID())
+	crashDir := crashWALDir(t, dir)
+
+	// secondo processo: riproduce i segmenti lasciati dal primo
+	meter := &recordingMeter{}
+	errSink := loggerhandler.NewMemorySink()
+	restarted := makeWALHandler(t, loggerhandler.HandlerOptions{WALDir: crashDir}, meter, errSink)
+
+	out := errSink.String()
+	if !strings.Contains(out, "OpType: LostOnCrash ----- Span ID: "+pending.GetID()) {
+		t.Fatalf("expected pending span to be emitted as lost, got %q", out)
+	}
+	if !strings.Contains(out, `"msg":"before crash","order":"42"`) || !strings.Contains(out, `"msg":"still buffered"`) {
+		t.Fatalf("expected buffered records in replay output, got %q", out)
+	}
+	if strings.Contains(out, "already written") || strings.Contains(out, done.GetID()) {
+		t.Fatalf("expected written records and released spans to be skipped, got %q", out)
+	}
+	if got := strings.Count(out, loggerhandler.ErrLostOnCrash.Error()); got != 2 {
+		t.Fatalf("expected one lost-on-crash record per span, got %d: %q", got, out)
+	}
+	if got := meter.value("logger_lost_on_crash_spans"); got != 2 {
+		t.Fatalf("expected 2 lost spans, got %d", got)
+	}
+
+	crashed.Close()
+	restarted.Close()
+	for _, d := range []string{dir, crashDir} {
+		entries, err := os.ReadDir(d)
+		if err != nil {
+			t.Fatalf("read wal dir: %v", err)
+		}
+		if len(entries) != 0 {
+			t.Fatalf("expected no WAL files after clean Close, got %d", len(entries))
+		}
+	}
+}
+
+func TestWALKeepsSegmentsOfLiveHandlers(t *testing.T) {
+	dir := t.TempDir()
+	first := makeWALHandler(t, loggerhandler.HandlerOptions{WALDir: dir}, &fakeMeter{}, nil)
+	open := first.AddSpan(0, nil, 5, slog.LevelError)
+	open.Info("still running")
+
+	errSink := loggerhandler.NewMemorySink()
+	second := makeWALHandler(t, loggerhandler.HandlerOptions{WALDir: dir}, &fakeMeter{}, errSink)
+	if strings.Contains(errSink.String(), open.GetID()) {
+		t.Fatalf("expected spans of a live handler not to be replayed, got %q", errSink.String())
+	}
+	if got := walSegments(t, dir); got != 2 {
+		t.Fatalf("expected the segments of both handlers, got %d", got)
+	}
+
+	first.Close()
+	second.Close()
+	if got := walSegments(t, dir); got != 0 {
+		t.Fatalf("expected no segments after Close, got %d", got)
+	}
+}
+
+func TestWALDoesNotReplayDroppedCommands(t *testing.T) {
+	dir := t.TempDir()
+	sink := newStallSink()
+	logCfg := loggerhandler.NewLogConfigs(false, "", 1, 1, false)
+	logCfg.AddSink(sink)
+	lh := loggerhandler.NewLoggerHandlerWithOptions(logCfg, loggerhandler.NewLogConfigs(false, "", 1, 1, false), &fakeMeter{}, 1, loggerhandler.HandlerOptions{WALDir: dir})
+	defer lh.Close()
+	defer sink.release()
+
+	span := lh.AddSpan(0, nil, 5, slog.LevelDebug)
+	span.Info("in flight")
+	<-sink.entered
+	span.Info("queued")
+	span.Info("dropped")
+	if span.LastSendResult() != loggerhandler.SendDroppedFull {
+		t.Fatalf("expected the last log to be dropped, got %s", span.LastSendResult())
+	}
+
+	errSink := loggerhandler.NewMemorySink()
+	restarted := makeWALHandler(t, loggerhandler.HandlerOptions{WALDir: crashWALDir(t, dir)}, &fakeMeter{}, errSink)
+	defer restarted.Close()
+
+	out := errSink.String()
+	if !strings.Contains(out, `"msg":"in flight"`) || !strings.Contains(out, `"msg":"queued"`) {
+		t.Fatalf("expected unwritten records to be replayed, got %q", out)
+	}
+	if strings.Contains(out, `"msg":"dropped"`) {
+		t.Fatalf("expected the dropped command not to be replayed, got %q", out)
+	}
+}
+
+func TestWALRotatesAndRemovesCompletedSegments(t *testing.T) {
+	dir := t.TempDir()
+	lh := makeWALHandler(t, loggerhandler.HandlerOptions{WALDir: dir, WALSegmentSize: 512}, &fakeMeter{}, nil)
+	defer lh.Close()
+
+	longLived := lh.AddSpan(0, nil, 5, slog.LevelError)
+	longLived.Info("started first")
+	for i := 0; i < 50; i++ {
+		span := lh.AddSpan(0, nil, 5, slog.LevelError)
+		span.Info("short lived")
+		span.ReleaseSuccess()
+		waitSpanReleased(t, lh, span.GetID())
+	}
+	// il primo segmento resta finché lo span che vi compare non è rilasciato
+	waitWALSegments(t, dir, 2)
+	if got := walSegments(t, dir); got != 2 {
+		t.Fatalf("expected the first and the current segment, got %d", got)
+	}
+
+	longLived.ReleaseSuccess()
+	waitSpanReleased(t, lh, longLived.GetID())
+	waitWALSegments(t, dir, 1)
+}
+
+func TestWALWriteErrorsAreCounted(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "wal")
+	meter := &recordingMeter{}
+	lh := makeWALHandler(t, loggerhandler.HandlerOptions{WALDir: dir, WALSegmentSize: 128}, meter, nil)
+	defer lh.Close()
+
+	// la directory sparisce: la rotazione non può creare il nuovo segmento
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatalf("remove wal dir: %v", err)
+	}
+	if err := os.WriteFile(dir, nil, 0o644); err != nil {
+		t.Fatalf("replace wal dir: %v", err)
+	}
+	span := lh.AddSpan(0, nil, 5, slog.LevelError)
+	for i := 0; i < 10; i++ {
+		span.Info("not persisted")
+	}
+	if meter.value("logger_wal_errors") == 0 {
+		t.Fatal("expected WAL write errors to be counted")
+	}
+}
+
+func TestWALReplaysRecordsKeptByFlightRecorder(t *testing.T) {
+	dir := t.TempDir()
+	crashed := makeWALHandler(t, loggerhandler.HandlerOptions{WALDir: dir}, &fakeMeter{}, nil)
+	crashed.SetSpanMode(loggerhandler.SpanModeFlightRecorder)
+	span := crashed.AddSpan(0, nil, 5, slog.LevelWarn)
+	span.Debug("kept in span")
+	span.Warn("sent immediately")
+
+	// attendo che il warning sia stato scritto e segnato come elaborato
+	deadline := time.Now().Add(time.Second)
+	for {
+		segments, _ := filepath.Glob(filepath.Join(dir, "wal-*.log"))
+		data, _ := os.ReadFile(segments[0])
+		if strings.Contains(string(data), `"t":"done"`) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("warning not processed")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	errSink := loggerhandler.NewMemorySink()
+	restarted := makeWALHandler(t, loggerhandler.HandlerOptions{WALDir: crashWALDir(t, dir)}, &fakeMeter{}, errSink)
+	defer restarted.Close()
+	defer crashed.Close()
+
+	out := errSink.String()
+	if !strings.Contains(out, `"msg":"kept in span"`) || strings.Contains(out, "sent immediately") {
+		t.Fatalf("expected only the record kept in the span to be replayed, got %q", out)
+	}
+}
@@ -0,0 +1,104 @@
+package loggerhandler_test
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	loggerhandler "github.com/Mrpagio/logger-handler"
+)
+
+// helper: crea un LoggerHandler silenzioso che scrive i log su logSinks e gli errori su errSinks
+// e registra le metriche in un recordingMeter
+func makeSinkTestHandler(t *testing.T, bufferSize int, logSinks []loggerhandler.Sink, errSinks []loggerhandler.Sink) (*loggerhandler.LoggerHandler, *recordingMeter) {
+	t.Helper()
+	logCfg := loggerhandler.NewLogConfigs(false, "", 1, 1, false)
+	errCfg := loggerhandler.NewLogConfigs(false, "", 1, 1, false)
+	for _, s := range logSinks {
+		logCfg.AddSink(s)
+	}
+	for _, s := range errSinks {
+		errCfg.AddSink(s)
+	}
+	meter := &recordingMeter{}
+	return loggerhandler.NewLoggerHandler(logCfg, errCfg, meter, bufferSize), meter
+}
+
+func TestWriteErrorCallbackFallbackAndDeadLetter(t *testing.T) {
+	lh, meter := makeSinkTestHandler(t, 10,
+		[]loggerhandler.Sink{failingSink{err: errors.New("disk full")}},
+		[]loggerhandler.Sink{loggerhandler.NewMemorySink()})
+
+	deadLetterPath := filepath.Join(t.TempDir(), "dead-letter.log")
+	if err := lh.SetDeadLetterFile(deadLetterPath); err != nil {
+		t.Fatalf("dead letter: %v", err)
+	}
+	fallback := loggerhandler.NewMemorySink()
+	lh.SetFallbackWriter(fallback)
+
+	var mu sync.Mutex
+	var failedSpans []string
+	lh.SetOnWriteError(func(cmd loggerhandler.LogCommand, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if len(loggerhandler.SinkErrors(err)) != 1 {
+			t.Errorf("expected one sink error, got %v", err)
+		}
+		failedSpans = append(failedSpans, cmd.SpanID)
+	})
+
+	lost := lh.AddSpan(0, nil, 5, slog.LevelError)
+	lost.Info("not written")
+	lost.ReleaseSuccess()
+	written := lh.AddSpan(0, nil, 5, slog.LevelError)
+	written.Error("written to error sink")
+	lh.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(failedSpans) != 1 || failedSpans[0] != lost.GetID() {
+		t.Fatalf("expected callback for the lost span only, got %v", failedSpans)
+	}
+	if !strings.Contains(fallback.String(), `"msg":"not written"`) {
+		t.Fatalf("expected output on fallback writer, got %q", fallback.String())
+	}
+	data, err := os.ReadFile(deadLetterPath)
+	if err != nil {
+		t.Fatalf("read dead letter: %v", err)
+	}
+	if !strings.Contains(string(data), `"msg":"not written"`) || strings.Contains(string(data), "written to error sink") {
+		t.Fatalf("unexpected dead letter content: %q", data)
+	}
+	if got := meter.value("logger_write_errors"); got != 1 {
+		t.Fatalf("expected logger_write_errors=1, got %d", got)
+	}
+}
+
+func TestFallbackSkippedOnPartialFailure(t *testing.T) {
+	healthy := loggerhandler.NewMemorySink()
+	lh, _ := makeSinkTestHandler(t, 10,
+		[]loggerhandler.Sink{failingSink{err: errors.New("disk full")}, healthy}, nil)
+	fallback := loggerhandler.NewMemorySink()
+	lh.SetFallbackWriter(fallback)
+	called := false
+	lh.SetOnWriteError(func(loggerhandler.LogCommand, error) { called = true })
+
+	span := lh.AddSpan(0, nil, 5, slog.LevelError)
+	span.Info("partial")
+	span.ReleaseSuccess()
+	lh.Close()
+
+	if !called {
+		t.Fatal("expected write error callback on partial failure")
+	}
+	if fallback.String() != "" {
+		t.Fatalf("expected no fallback output when a sink succeeded, got %q", fallback.String())
+	}
+	if !strings.Contains(healthy.String(), `"msg":"partial"`) {
+		t.Fatalf("expected healthy sink output, got %q", healthy.String())
+	}
+}
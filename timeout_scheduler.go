@@ -0,0 +1,245 @@
+package loggerhandler
+
+import (
+	"container/heap"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// timeoutEntry è la scadenza di uno span nel min-heap dello scheduler.
+type timeoutEntry struct {
+	spanID   string
+	deadline time.Time
+	// posizione nell'heap, mantenuta da timeoutHeap per permettere la rimozione in O(log n)
+	index int
+}
+
+// timeoutHeap è un min-heap di scadenze ordinate per deadline (implementa heap.Interface).
+type timeoutHeap []*timeoutEntry
+
+// Len restituisce il numero di scadenze nell'heap.
+// Parametri: nessuno
+// Ritorna: int
+func (h timeoutHeap) Len() int { return len(h) }
+
+// Less indica se la scadenza in posizione i precede quella in posizione j.
+// Parametri:
+//   - i: posizione della prima scadenza
+//   - j: posizione della seconda scadenza
+//
+// Ritorna: bool
+func (h timeoutHeap) Less(i, j int) bool { return h[i].deadline.Before(h[j].deadline) }
+
+// Swap scambia due scadenze aggiornandone la posizione.
+// Parametri:
+//   - i: posizione della prima scadenza
+//   - j: posizione della seconda scadenza
+//
+// Ritorna: nulla
+func (h timeoutHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+// Push aggiunge una scadenza in fondo all'heap (usato da heap.Push).
+// Parametri: x *timeoutEntry da aggiungere
+// Ritorna: nulla
+func (h *timeoutHeap) Push(x any) {
+	entry := x.(*timeoutEntry)
+	entry.index = len(*h)
+	*h = append(*h, entry)
+}
+
+// Pop toglie l'ultima scadenza dell'heap (usato da heap.Pop e heap.Remove).
+// Cosa fa: azzera la posizione della voce, che non appartiene più all'heap.
+// Parametri: nessuno
+// Ritorna: *timeoutEntry rimossa
+func (h *timeoutHeap) Pop() any {
+	old := *h
+	n := len(old)
+	entry := old[n-1]
+	old[n-1] = nil
+	entry.index = -1
+	*h = old[:n-1]
+	return entry
+}
+
+// timeoutScheduler gestisce i timeout di tutti gli span con un'unica goroutine.
+// Cosa fa: mantiene le scadenze in un min-heap e usa un solo time.Timer impostato sulla
+//
+//	scadenza più vicina. Una scadenza viene tolta dall'heap sotto mutex prima di essere
+//	notificata, quindi ogni timeout scatta al più una volta e mai dopo cancel; nessuna
+//	scadenza viene persa perché la notifica non passa da un canale con capacità limitata
+//	e AppendCommand accoda OpTimeout attendendo spazio, qualunque sia la BackpressurePolicy.
+//
+// Parametri: nessuno
+// Ritorna: nessuna (è una struttura)
+type timeoutScheduler struct {
+	mu      sync.Mutex
+	heap    timeoutHeap
+	entries map[string]*timeoutEntry
+	// wake risveglia la goroutine quando cambia la scadenza più vicina
+	wake chan struct{}
+	stop chan struct{}
+	done chan struct{}
+	// callback invocata dalla goroutine dello scheduler alla scadenza di uno span
+	fire func(spanID string)
+}
+
+// newTimeoutScheduler crea lo scheduler e avvia la sua goroutine.
+// Parametri: fire callback invocata con l'id dello span scaduto
+// Ritorna: *timeoutScheduler
+func newTimeoutScheduler(fire func(spanID string)) *timeoutScheduler {
+	ts := &timeoutScheduler{
+		entries: make(map[string]*timeoutEntry),
+		wake:    make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+		fire:    fire,
+	}
+	go ts.run()
+	return ts
+}
+
+// schedule pianifica (o ripianifica) il timeout di uno span.
+// Parametri:
+//   - spanID: identificatore dello span
+//   - deadline: istante di scadenza
+//
+// Ritorna: nulla
+func (ts *timeoutScheduler) schedule(spanID string, deadline time.Time) {
+	ts.mu.Lock()
+	if entry, ok := ts.entries[spanID]; ok {
+		entry.deadline = deadline
+		heap.Fix(&ts.heap, entry.index)
+	} else {
+		entry = &timeoutEntry{spanID: spanID, deadline: deadline}
+		heap.Push(&ts.heap, entry)
+		ts.entries[spanID] = entry
+	}
+	first := ts.heap[0].spanID == spanID
+	ts.mu.Unlock()
+	// risveglio la goroutine solo se la scadenza più vicina è cambiata
+	if first {
+		ts.notify()
+	}
+}
+
+// cancel rimuove il timeout di uno span; non ha effetto se è già scattato o non esiste.
+// Parametri: spanID identificatore dello span
+// Ritorna: true se il timeout era ancora pianificato
+func (ts *timeoutScheduler) cancel(spanID string) bool {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	entry, ok := ts.entries[spanID]
+	if !ok {
+		return false
+	}
+	heap.Remove(&ts.heap, entry.index)
+	delete(ts.entries, spanID)
+	return true
+}
+
+// close ferma la goroutine e scarta i timeout ancora pianificati.
+// Cosa fa: al ritorno nessuna callback è in esecuzione né verrà più invocata.
+// Parametri: nessuno
+// Ritorna: nulla
+func (ts *timeoutScheduler) close() {
+	close(ts.stop)
+	<-ts.done
+	ts.mu.Lock()
+	ts.heap = nil
+	ts.entries = make(map[string]*timeoutEntry)
+	ts.mu.Unlock()
+}
+
+// notify risveglia la goroutine dello scheduler.
+// Cosa fa: l'invio non blocca; se un risveglio è già pendente non ne serve un altro,
+//
+//	perché la goroutine rilegge comunque la scadenza più vicina.
+//
+// Parametri: nessuno
+// Ritorna: nulla
+func (ts *timeoutScheduler) notify() {
+	select {
+	case ts.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run è il ciclo della goroutine dello scheduler.
+// Cosa fa: toglie dall'heap e notifica con fire tutte le scadenze raggiunte, poi dorme
+//
+//	fino alla successiva o finché notify non segnala una scadenza più vicina. Con l'heap
+//	vuoto il timer resta fermo, quindi la goroutine non consuma CPU. Termina con close.
+//
+// Parametri: nessuno
+// Ritorna: nulla
+func (ts *timeoutScheduler) run() {
+	defer close(ts.done)
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+	for {
+		// notifico tutte le scadenze raggiunte e calcolo l'attesa per la successiva
+		var wait time.Duration
+		for {
+			ts.mu.Lock()
+			if len(ts.heap) == 0 {
+				ts.mu.Unlock()
+				wait = -1
+				break
+			}
+			wait = time.Until(ts.heap[0].deadline)
+			if wait > 0 {
+				ts.mu.Unlock()
+				break
+			}
+			entry := heap.Pop(&ts.heap).(*timeoutEntry)
+			delete(ts.entries, entry.spanID)
+			ts.mu.Unlock()
+
+			select {
+			case <-ts.stop:
+				return
+			default:
+			}
+			ts.fire(entry.spanID)
+		}
+
+		if wait > 0 {
+			timer.Reset(wait)
+		}
+		select {
+		case <-ts.stop:
+			timer.Stop()
+			return
+		case <-ts.wake:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// fireTimeout è la callback dello scheduler: chiama Timeout sullo span scaduto.
+// Cosa fa: se lo span non è più registrato incrementa il contatore degli span non validi.
+// Parametri: spanID identificatore dello span scaduto
+// Ritorna: nulla
+func (lh *LoggerHandler) fireTimeout(spanID string) {
+	if atomic.LoadInt32(&lh.closing) == 1 {
+		return
+	}
+	lh.mu.Lock()
+	span := lh.spans[spanID]
+	lh.mu.Unlock()
+
+	if span == nil {
+		// Le metriche sono opzionali: aggiorniamo solo se `lh.meter` è presente.
+		if lh.meter != nil {
+			lh.invalidSpanCounter.Add(1)
+		}
+		return
+	}
+	span.Timeout()
+}
@@ -0,0 +1,214 @@
+package loggerhandler
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// TraceparentHeader è il nome dell'header W3C che trasporta trace ID e span ID.
+	TraceparentHeader = "traceparent"
+	// TracestateHeader è il nome dell'header W3C con lo stato specifico dei vendor.
+	TracestateHeader = "tracestate"
+
+	// traceparentVersion è l'unica versione del formato supportata in scrittura
+	traceparentVersion = "00"
+	// traceFlagSampled è il flag W3C che indica una trace campionata
+	traceFlagSampled = "01"
+	// traceFlagNotSampled è il flag W3C che indica una trace non campionata
+	traceFlagNotSampled = "00"
+)
+
+// ErrInvalidTraceparent indica un header traceparent malformato o non rappresentabile.
+var ErrInvalidTraceparent = errors.New("traceparent non valido")
+
+// FormatTraceparent costruisce il valore di un header W3C traceparent.
+// Parametri:
+//   - traceID: 32 cifre esadecimali, non tutte zero
+//   - spanID: 16 cifre esadecimali, non tutte zero
+//   - sampled: valore del flag di campionamento
+//
+// Ritorna: valore dell'header ed errore ErrInvalidTraceparent se gli id non sono validi
+func FormatTraceparent(traceID string, spanID string, sampled bool) (string, error) {
+	if !isValidHexID(traceID, 32) || !isValidHexID(spanID, 16) {
+		return "", ErrInvalidTraceparent
+	}
+	flags := traceFlagNotSampled
+	if sampled {
+		flags = traceFlagSampled
+	}
+	return traceparentVersion + "-" + traceID + "-" + spanID + "-" + flags, nil
+}
+
+// ParseTraceparent interpreta il valore di un header W3C traceparent.
+// Cosa fa: valida versione, trace ID, parent ID e flag secondo la specifica W3C Trace Context.
+// Parametri: value valore dell'header
+// Ritorna: trace ID, parent ID, flag sampled ed errore ErrInvalidTraceparent se malformato
+func ParseTraceparent(value string) (traceID string, parentID string, sampled bool, err error) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 {
+		return "", "", false, ErrInvalidTraceparent
+	}
+	version := parts[0]
+	// La versione ff è proibita; per la 00 sono ammessi esattamente 4 campi
+	if !isHex(version, 2) || version == "ff" || (version == traceparentVersion && len(parts) != 4) {
+		return "", "", false, ErrInvalidTraceparent
+	}
+	traceID, parentID, flags := parts[1], parts[2], parts[3]
+	if !isValidHexID(traceID, 32) || !isValidHexID(parentID, 16) || !isHex(flags, 2) {
+		return "", "", false, ErrInvalidTraceparent
+	}
+	raw, _ := hex.DecodeString(flags)
+	return traceID, parentID, raw[0]&0x01 == 0x01, nil
+}
+
+// isHex indica se s è composto da esattamente n cifre esadecimali minuscole.
+// Parametri:
+//   - s: stringa da controllare
+//   - n: lunghezza attesa
+//
+// Ritorna: bool
+func isHex(s string, n int) bool {
+	if len(s) != n {
+		return false
+	}
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// isValidHexID indica se s è un id esadecimale di n cifre diverso da tutti zero.
+// Parametri:
+//   - s: stringa da controllare
+//   - n: lunghezza attesa
+//
+// Ritorna: bool
+func isValidHexID(s string, n int) bool {
+	return isHex(s, n) && strings.Trim(s, "0") != ""
+}
+
+// w3cSpanID converte l'id di uno span nel formato W3C a 16 cifre esadecimali.
+// Cosa fa: un id già in formato W3C viene restituito invariato; per gli UUID generati
+//
+//	da generateSpanID si usano le ultime 16 cifre esadecimali (parte casuale del UUID v7).
+//
+// Parametri: id identificatore dello span
+// Ritorna: id W3C e flag che indica se la conversione è riuscita
+func w3cSpanID(id string) (string, bool) {
+	if isValidHexID(id, 16) {
+		return id, true
+	}
+	compact := strings.ReplaceAll(id, "-", "")
+	if !isHex(compact, 32) {
+		return "", false
+	}
+	spanID := compact[16:]
+	return spanID, isValidHexID(spanID, 16)
+}
+
+// Traceparent restituisce il valore dell'header W3C traceparent che identifica lo span.
+// Cosa fa: se lo span è collegato a uno span di tracing usa lo span ID di quest'ultimo,
+//
+//	così che il servizio chiamato risulti figlio dello span di tracing.
+//
+// Parametri: nessuno
+// Ritorna: valore dell'header ed errore ErrInvalidTraceparent se trace o span ID non sono rappresentabili
+func (sl *SpanLogger) Traceparent() (string, error) {
+	if sl.otelSpan != nil {
+		if sc := sl.otelSpan.SpanContext(); sc.IsValid() {
+			return FormatTraceparent(sc.TraceID().String(), sc.SpanID().String(), sl.sampled)
+		}
+	}
+	spanID := sl.w3cID()
+	if spanID == "" {
+		return "", ErrInvalidTraceparent
+	}
+	return FormatTraceparent(sl.traceID, spanID, sl.sampled)
+}
+
+// w3cID restituisce lo span ID W3C con cui lo span si presenta ai servizi chiamati.
+// Cosa fa: è il parent-id scritto da Traceparent e viene riportato nell'output dei comandi,
+//
+//	così che il Parent ID registrato dal servizio chiamato corrisponda a un id del chiamante.
+//	Con uno span di tracing collegato è lo span ID di quest'ultimo.
+//
+// Parametri: nessuno
+// Ritorna: string (vuota se trace ID o span ID non sono rappresentabili nel formato W3C)
+func (sl *SpanLogger) w3cID() string {
+	if sl.otelSpan != nil {
+		if sc := sl.otelSpan.SpanContext(); sc.IsValid() {
+			return sc.SpanID().String()
+		}
+	}
+	if !isValidHexID(sl.traceID, 32) {
+		return ""
+	}
+	spanID, ok := w3cSpanID(sl.id)
+	if !ok {
+		return ""
+	}
+	return spanID
+}
+
+// GetTraceState restituisce il valore tracestate ricevuto o ereditato dallo span.
+// Parametri: nessuno
+// Ritorna: string (vuota se assente)
+func (sl *SpanLogger) GetTraceState() string {
+	return sl.traceState
+}
+
+// InjectTraceContext scrive gli header traceparent e tracestate per una chiamata in uscita.
+// Cosa fa: permette al servizio chiamato di creare uno span figlio con lo stesso trace ID.
+// Parametri: header header HTTP della richiesta in uscita
+// Ritorna: errore ErrInvalidTraceparent se lo span non è rappresentabile nel formato W3C
+func (sl *SpanLogger) InjectTraceContext(header http.Header) error {
+	value, err := sl.Traceparent()
+	if err != nil {
+		return err
+	}
+	header.Set(TraceparentHeader, value)
+	if sl.traceState != "" {
+		header.Set(TracestateHeader, sl.traceState)
+	}
+	return nil
+}
+
+// AddSpanFromTraceContext crea uno span a partire dagli header W3C di una richiesta in ingresso.
+// Cosa fa: se l'header traceparent è valido lo span adotta il trace ID ricevuto e usa lo span
+//
+//	chiamante come ParentID; altrimenti viene creato uno span radice con una nuova trace.
+//
+// Parametri:
+//   - header: header HTTP della richiesta in ingresso
+//   - duration: durata del timeout dello span (0 per nessun timeout)
+//   - tags: lista di tag associati allo span
+//   - bufferSize: dimensione del buffer interno dello span
+//   - level: livello minimo di log che causa l'invio immediato
+//
+// Ritorna: il nuovo SpanLogger (sempre valido) ed ErrInvalidTraceparent se l'header era presente ma malformato
+func (lh *LoggerHandler) AddSpanFromTraceContext(header http.Header, duration time.Duration, tags []string, bufferSize int, level slog.Level) (*SpanLogger, error) {
+	value := header.Get(TraceparentHeader)
+	if value == "" {
+		return lh.AddSpan(duration, tags, bufferSize, level), nil
+	}
+
+	traceID, parentID, sampled, err := ParseTraceparent(value)
+	if err != nil {
+		return lh.AddSpan(duration, tags, bufferSize, level), err
+	}
+
+	span := lh.newSpan(duration, tags, bufferSize, level)
+	span.parentID = parentID
+	span.traceID = traceID
+	span.sampled = sampled
+	span.traceState = header.Get(TracestateHeader)
+	return lh.registerSpan(context.Background(), span), nil
+}
@@ -0,0 +1,77 @@
+package loggerhandler
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TracerInterface espone il metodo usato per avviare span di tracing OpenTelemetry.
+// Cosa fa: fornisce un'astrazione minima sul tracer, come MeterInterface per le metriche,
+//
+//	così che il pacchetto non dipenda da una specifica implementazione dell'SDK
+//	e i test possano usare un tracer in memoria.
+//
+// Parametri: nessuno
+// Ritorna: nessuna (è un'interfaccia)
+type TracerInterface interface {
+	// Start avvia un nuovo span di tracing.
+	// Parametri:
+	//  - ctx: contesto che può contenere lo span di tracing padre
+	//  - spanName: nome dello span
+	//  - opts: opzioni aggiuntive per l'avvio dello span
+	// Ritorna: contesto con il nuovo span e TraceSpanLike (implementazione specifica)
+	Start(ctx context.Context, spanName string, opts ...trace.SpanStartOption) (context.Context, TraceSpanLike)
+}
+
+// TraceSpanLike è un tipo placeholder che espone i metodi di uno span di tracing.
+// Cosa fa: permette di impostare lo stato e chiudere lo span senza dipendere dall'implementazione reale.
+// Parametri: nessuno
+// Ritorna: nessuno (è un'interfaccia)
+type TraceSpanLike interface {
+	// SpanContext restituisce trace ID e span ID dello span di tracing.
+	// Parametri: nessuno
+	// Ritorna: trace.SpanContext
+	SpanContext() trace.SpanContext
+	// SetStatus imposta lo stato finale dello span.
+	// Parametri:
+	//  - code: codice di stato (Ok o Error)
+	//  - description: descrizione, usata solo per lo stato Error
+	// Ritorna: nulla
+	SetStatus(code codes.Code, description string)
+	// RecordError registra un errore come evento dello span.
+	// Parametri:
+	//  - err: errore da registrare
+	//  - opts: opzioni addizionali per l'evento
+	// Ritorna: nulla
+	RecordError(err error, opts ...trace.EventOption)
+	// End chiude lo span.
+	// Parametri:
+	//  - opts: opzioni addizionali per la chiusura
+	// Ritorna: nulla
+	End(opts ...trace.SpanEndOption)
+}
+
+// otelTracer adatta un trace.Tracer di OpenTelemetry a TracerInterface.
+type otelTracer struct {
+	tracer trace.Tracer
+}
+
+// NewOtelTracer crea un TracerInterface a partire da un trace.Tracer di OpenTelemetry.
+// Parametri: tracer tracer dell'SDK (ad esempio otel.Tracer("nome"))
+// Ritorna: TracerInterface
+func NewOtelTracer(tracer trace.Tracer) TracerInterface {
+	return &otelTracer{tracer: tracer}
+}
+
+// Start avvia uno span tramite il tracer di OpenTelemetry.
+// Parametri:
+//   - ctx: contesto che può contenere lo span di tracing padre
+//   - spanName: nome dello span
+//   - opts: opzioni aggiuntive per l'avvio dello span
+//
+// Ritorna: contesto con il nuovo span e lo span come TraceSpanLike
+func (t *otelTracer) Start(ctx context.Context, spanName string, opts ...trace.SpanStartOption) (context.Context, TraceSpanLike) {
+	return t.tracer.Start(ctx, spanName, opts...)
+}
@@ -0,0 +1,23 @@
+//go:build !unix
+
+package loggerhandler
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+)
+
+// lockWALOwner acquisisce il file di lock di un proprietario del write-ahead log.
+// Cosa fa: senza flock si affida al fatto che un file aperto dal proprietario non può essere
+//
+//	rimosso (come avviene su Windows): se la rimozione fallisce il proprietario è attivo.
+//
+// Parametri: path percorso del file di lock
+// Ritorna: file di lock (da chiudere per rilasciare il lock), errWALLocked se il lock è in uso
+func lockWALOwner(path string) (*os.File, error) {
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, errWALLocked
+	}
+	return os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_RDWR, 0o644)
+}
@@ -0,0 +1,31 @@
+//go:build unix
+
+package loggerhandler
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// lockWALOwner acquisisce il lock esclusivo sul file di lock di un proprietario del write-ahead log.
+// Cosa fa: usa flock, rilasciato dal sistema operativo quando il processo termina, così i
+//
+//	segmenti di un processo crashato possono essere riprodotti e quelli di un processo attivo no.
+//
+// Parametri: path percorso del file di lock (creato se assente)
+// Ritorna: file di lock (da chiudere per rilasciare il lock), errWALLocked se il lock è in uso
+func lockWALOwner(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		_ = f.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, errWALLocked
+		}
+		return nil, err
+	}
+	return f, nil
+}
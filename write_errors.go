@@ -0,0 +1,129 @@
+package loggerhandler
+
+import (
+	"errors"
+	"io"
+	"os"
+)
+
+// SetOnWriteError imposta la callback invocata quando l'output di un comando non viene scritto.
+// Cosa fa: la callback riceve il comando e l'errore (formattazione, sink falliti ed eventuali
+//
+//	errori di fallback e dead-letter combinati); viene chiamata dalla goroutine di
+//	scrittura e non deve bloccare.
+//
+// Parametri: fn callback (nil la disabilita)
+// Ritorna: nulla
+func (lh *LoggerHandler) SetOnWriteError(fn func(cmd LogCommand, err error)) {
+	lh.mu.Lock()
+	defer lh.mu.Unlock()
+	lh.onWriteError = fn
+}
+
+// SetFallbackWriter imposta la destinazione usata quando l'output non raggiunge nessun sink.
+// Cosa fa: se tutti i sink del writer falliscono (o la scrittura fallisce prima dei sink)
+//
+//	l'output formattato viene scritto su w, ad esempio os.Stderr. Dopo Close riceve in
+//	modo sincrono i comandi rifiutati. w non viene chiuso da Close.
+//
+// Parametri: w writer di fallback (nil lo disabilita)
+// Ritorna: nulla
+func (lh *LoggerHandler) SetFallbackWriter(w io.Writer) {
+	lh.mu.Lock()
+	defer lh.mu.Unlock()
+	lh.fallbackWriter = w
+}
+
+// SetDeadLetterFile abilita il file dead-letter per l'output non scritto.
+// Cosa fa: ogni volta che la scrittura fallisce, anche su un solo sink, l'output formattato
+//
+//	viene accodato al file così da poterlo riprodurre in seguito. Il file è chiuso da Close.
+//
+// Parametri: path percorso del file (vuoto disabilita il dead-letter)
+// Ritorna: errore di apertura del file
+func (lh *LoggerHandler) SetDeadLetterFile(path string) error {
+	var sink Sink
+	if path != "" {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return err
+		}
+		sink = NewWriterSink(f)
+	}
+
+	lh.mu.Lock()
+	previous := lh.deadLetter
+	lh.deadLetter = sink
+	lh.mu.Unlock()
+
+	if previous != nil {
+		return previous.Close()
+	}
+	return nil
+}
+
+// GetWriteErrorCounter restituisce il contatore dei comandi il cui output non è stato scritto.
+// Parametri: nessuno
+// Ritorna: Int64CounterLike
+func (lh *LoggerHandler) GetWriteErrorCounter() Int64CounterLike {
+	return lh.writeErrorCounter
+}
+
+// handleWriteError gestisce un comando il cui output non è stato scritto correttamente.
+// Cosa fa: aggiorna la metrica logger_write_errors, salva l'output nel dead-letter,
+//
+//	lo scrive sul fallback se nessun sink lo ha ricevuto e invoca la callback OnWriteError.
+//
+// Parametri:
+//   - output: output formattato del comando
+//   - cmd: comando elaborato
+//   - err: errore di formattazione o scrittura
+//   - delivered: true se almeno un sink ha ricevuto l'output
+//
+// Ritorna: nulla
+func (lh *LoggerHandler) handleWriteError(output string, cmd LogCommand, err error, delivered bool) {
+	if lh.meter != nil {
+		lh.writeErrorCounter.Add(1)
+	}
+
+	lh.mu.Lock()
+	onWriteError := lh.onWriteError
+	fallback := lh.fallbackWriter
+	deadLetter := lh.deadLetter
+	lh.mu.Unlock()
+
+	if output != "" && deadLetter != nil {
+		lh.fallbackMu.Lock()
+		_, dlErr := io.WriteString(deadLetter, output)
+		lh.fallbackMu.Unlock()
+		if dlErr != nil {
+			err = errors.Join(err, dlErr)
+		}
+	}
+	if output != "" && fallback != nil && !delivered {
+		lh.fallbackMu.Lock()
+		_, fbErr := io.WriteString(fallback, output)
+		lh.fallbackMu.Unlock()
+		if fbErr != nil {
+			err = errors.Join(err, fbErr)
+		}
+	}
+
+	if onWriteError != nil {
+		onWriteError(cmd, err)
+	}
+}
+
+// deliveredToAnySink indica se almeno un sink del writer ha ricevuto l'output.
+// Parametri:
+//   - cmd: comando elaborato (determina il writer di destinazione)
+//   - writeErr: errore restituito dalla scrittura sui sink
+//
+// Ritorna: bool
+func (lh *LoggerHandler) deliveredToAnySink(cmd LogCommand, writeErr error) bool {
+	if writeErr == nil {
+		return true
+	}
+	failed := len(SinkErrors(writeErr))
+	return failed > 0 && failed < len(lh.writerFor(cmd.Op).GetSinks())
+}